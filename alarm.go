@@ -1,12 +1,14 @@
 package alarm
 
 import (
-	"context"
+	"errors"
 	"fmt"
+	"math"
 	"regexp"
-	"strings"
 	"sync"
 	"time"
+
+	"github.com/a-h/alarm/logging"
 )
 
 // State is Armed, Disarmed or Triggered.
@@ -14,7 +16,7 @@ type State int
 
 const (
 	// Disarmed is the initial state.
-	Disarmed = iota
+	Disarmed State = iota
 	// Arming the alarm.
 	Arming
 	// Armed is when the alarm is ready.
@@ -34,43 +36,154 @@ var StateNames = map[State]string{
 	Triggered:  "Triggered",
 }
 
-// New creates a new Alarm.
+// Mode determines which zones are monitored while the alarm is armed.
+type Mode int
+
+const (
+	// Away monitors every zone.
+	Away Mode = iota
+	// Home ignores motion zones, so that people can move around inside.
+	Home
+	// Night ignores motion zones, and entry/exit zones trigger the alarm
+	// without an entry delay.
+	Night
+)
+
+// ModeNames contains the names of the various modes.
+var ModeNames = map[Mode]string{
+	Away:  "Away",
+	Home:  "Home",
+	Night: "Night",
+}
+
+// Cause of a state transition.
+type Cause string
+
+const (
+	// CauseKeypad is a command entered on the keypad.
+	CauseKeypad Cause = "keypad"
+	// CauseZone is a zone being opened.
+	CauseZone Cause = "zone"
+	// CauseTimer is a countdown completing.
+	CauseTimer Cause = "timer"
+	// CauseRemote is a method call on the Alarm, e.g. from a command received over MQTT.
+	CauseRemote Cause = "remote"
+	// CauseRestore is the alarm resuming its state after a restart.
+	CauseRestore Cause = "restore"
+)
+
+// Event is sent to subscribers when the alarm changes.
+type Event interface {
+	event()
+}
+
+// Transition is sent when the alarm changes state.
+type Transition struct {
+	From  State
+	To    State
+	Mode  Mode
+	Cause Cause
+	// User that entered the code, if the transition was caused by a command.
+	User string
+	Time time.Time
+}
+
+func (Transition) event() {}
+
+// DisplayChanged is sent when the text to display changes.
+type DisplayChanged struct {
+	Display string
+	Time    time.Time
+}
+
+func (DisplayChanged) event() {}
+
+// Lockout is sent when too many incorrect codes have been entered.
+type Lockout struct {
+	Failures int
+	// Until is when codes will be accepted again.
+	Until time.Time
+	Time  time.Time
+}
+
+func (Lockout) event() {}
+
+// CodeRejected is sent when an incorrect code is entered.
+type CodeRejected struct {
+	// Failures is the number of consecutive incorrect codes.
+	Failures int
+	Cause    Cause
+	Time     time.Time
+}
+
+func (CodeRejected) event() {}
+
+// RemoteCommand is sent when a command is executed with Execute, e.g. one
+// received over MQTT.
+type RemoteCommand struct {
+	State State
+	Mode  Mode
+	// Err is the reason the command was rejected, if it was.
+	Err  error
+	Time time.Time
+}
+
+func (RemoteCommand) event() {}
+
+var (
+	// ErrBadCode is returned when an incorrect code is entered.
+	ErrBadCode = errors.New("alarm: incorrect code")
+	// ErrLockedOut is returned when a code is entered during a lockout.
+	ErrLockedOut = errors.New("alarm: locked out after too many incorrect codes")
+	// ErrInvalidTransition is returned when a command can't be carried out in
+	// the current state, e.g. arming an alarm that is already armed.
+	ErrInvalidTransition = errors.New("alarm: invalid transition")
+)
+
+// New creates a new Alarm and starts its event loop. The code is used by an
+// admin user, who can add other users. If the code is empty, no users are
+// added, e.g. because they will be restored from a Store.
 func New(code string) *Alarm {
 	a := &Alarm{
-		State:      Disarmed,
-		Code:       code,
-		LowBeep:    func() {},
-		MediumBeep: func() {},
-		HighBeep:   func() {},
-		StartAlarm: func() {},
-		StopAlarm:  func() {},
-		Logger:     func(format string, v ...interface{}) {},
+		LowBeep:       func() {},
+		MediumBeep:    func() {},
+		HighBeep:      func() {},
+		StartAlarm:    func() {},
+		StopAlarm:     func() {},
+		Logger:        logging.Nop{},
+		LockoutAfter:  3,
+		LockoutDelay:  time.Second * 30,
+		state:         Disarmed,
+		ExitDelay:     time.Second * 30,
+		EntryDelay:    time.Second * 30,
+		Warning:       time.Second * 10,
+		SirenDuration: time.Minute * 15,
+		tick:          time.Second,
+		ops:           make(chan op),
+		quit:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+		subscribers:   make(map[chan Event]struct{}),
 	}
-	a.Timeout = func(ctx context.Context) {
-		for i := 30; i > 0; i-- {
-			if ctx.Err() == context.Canceled {
-				return
-			}
-			a.LowBeep()
-			a.Display = fmt.Sprintf("%d", i)
-			time.Sleep(time.Second)
-			if i == 1 {
-				a.Display = "0"
-				a.LowBeep()
-				a.MediumBeep()
-				a.HighBeep()
-				a.Display = ""
-			}
+	if code != "" {
+		admin := User{Name: "admin", Role: RoleAdmin}
+		var err error
+		if admin.Hash, err = HashCode(code); err != nil {
+			// Only possible if the code is longer than 72 bytes.
+			panic(err)
 		}
+		a.users = append(a.users, admin)
 	}
+	go a.run()
 	return a
 }
 
-// Alarm which has door status
+// Alarm which monitors a set of zones.
+//
+// All changes to the alarm are processed one at a time by an event loop, so the
+// electronics callbacks are only ever called from the event loop's goroutine.
+// They, and the Logger, must be set before the alarm is used, and must not
+// call back into the Alarm.
 type Alarm struct {
-	State State
-	Code  string
-
 	// Electronics interactions.
 	LowBeep    func()
 	MediumBeep func()
@@ -78,28 +191,247 @@ type Alarm struct {
 	StartAlarm func()
 	StopAlarm  func()
 
+	Logger logging.Logger
+
+	// LockoutAfter is the number of consecutive incorrect codes that lock out
+	// further attempts. Zero disables the lockout.
+	LockoutAfter int
+	// LockoutDelay is how long the first lockout lasts. Each further lockout
+	// before a correct code is entered lasts twice as long as the last.
+	LockoutDelay time.Duration
+	// TriggerAfter is the number of consecutive incorrect codes that trigger
+	// the alarm immediately during the entry delay. Zero disables it.
+	TriggerAfter int
+
+	// ExitDelay is how long there is to leave after arming the alarm.
+	ExitDelay time.Duration
+	// EntryDelay is how long there is to disarm the alarm after an entry/exit
+	// zone is opened, unless the zone has its own EntryDelay.
+	EntryDelay time.Duration
+	// Warning is the end of the exit and entry delays, when the keypad beeps
+	// faster.
+	Warning time.Duration
+	// SirenDuration is how long the siren sounds once the alarm is triggered.
+	// Zero sounds the siren until the alarm is disarmed.
+	SirenDuration time.Duration
+	// Rearm the alarm once the siren stops. Otherwise, the alarm memory is
+	// shown on the display until the alarm is disarmed and the memory is
+	// acknowledged.
+	Rearm bool
+
+	// Fields below are owned by the event loop.
+	state State
+	mode  Mode
+	users []User
+	store Store
+	// user that entered the code for the command currently being executed.
+	user string
 	// buffer of pressed keys.
-	buffer     string
-	Failures   int
-	doorIsOpen bool
-	Display    string
+	buffer   string
+	failures int
+	// alarmZones that have caused the alarm to trigger since it was armed.
+	alarmZones []string
+	memory     *Memory
+	// triggeredFrom is the state the alarm was in when it was triggered.
+	triggeredFrom State
+	// lockouts since the last correct code.
+	lockouts    int
+	lockedUntil time.Time
+	zones       []Zone
+	display     string
+	// keysDisplay is the last display of the pressed keys, used to tell
+	// whether the display is still showing them.
+	keysDisplay string
+	// generation is incremented on every state change, so that timers started
+	// in a previous state do nothing when they expire.
+	generation int
+	// pending events to send to subscribers.
+	pending []Event
+	// tick is the interval between countdown beeps.
+	tick time.Duration
+
+	ops     chan op
+	quit    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+
+	// m protects status, which is a copy of the state made after each event,
+	// and subscribers.
+	m           sync.Mutex
+	status      Status
+	subscribers map[chan Event]struct{}
+	closed      bool
+}
+
+// Status is a consistent snapshot of the alarm.
+type Status struct {
+	State    State
+	Mode     Mode
+	Display  string
+	Failures int
+	// LockedUntil is set during a lockout.
+	LockedUntil time.Time
+	// Memory is set after the alarm has been triggered, until it is acknowledged.
+	Memory *Memory
+	Zones  []Zone
+	// ShowingKeys is true if the display shows the keys that were pressed,
+	// which may include a code, rather than a countdown or message.
+	ShowingKeys bool
+}
+
+// Command is a request to change the state of the alarm, e.g. received over MQTT.
+type Command struct {
+	State State
+	// Mode to arm the alarm in.
+	Mode Mode
+	Code string
+}
+
+// op is an event processed by the event loop.
+type op struct {
+	f    func()
+	done chan struct{}
+}
+
+func (a *Alarm) run() {
+	defer close(a.stopped)
+	for {
+		select {
+		case o := <-a.ops:
+			o.f()
+			a.m.Lock()
+			previousDisplay := a.status.Display
+			a.status = Status{
+				State:       a.state,
+				Mode:        a.mode,
+				Display:     a.display,
+				Failures:    a.failures,
+				LockedUntil: a.lockedUntil,
+				Memory:      a.memory,
+				Zones:       append([]Zone(nil), a.zones...),
+				ShowingKeys: a.display != "" && a.display == a.keysDisplay,
+			}
+			a.m.Unlock()
+			if a.display != previousDisplay {
+				a.emit(DisplayChanged{Display: a.display, Time: time.Now()})
+			}
+			a.publish()
+			if o.done != nil {
+				close(o.done)
+			}
+		case <-a.quit:
+			a.m.Lock()
+			a.closed = true
+			for s := range a.subscribers {
+				close(s)
+				delete(a.subscribers, s)
+			}
+			a.m.Unlock()
+			return
+		}
+	}
+}
+
+// Subscribe returns a channel that receives an Event whenever the alarm
+// changes, and a function to stop receiving them. Events are dropped if the
+// subscriber falls too far behind. The channel is closed when the alarm is
+// closed.
+func (a *Alarm) Subscribe() (events <-chan Event, unsubscribe func()) {
+	s := make(chan Event, 100)
+	a.m.Lock()
+	defer a.m.Unlock()
+	if a.closed {
+		close(s)
+		return s, func() {}
+	}
+	a.subscribers[s] = struct{}{}
+	unsubscribe = func() {
+		a.m.Lock()
+		defer a.m.Unlock()
+		if _, ok := a.subscribers[s]; ok {
+			close(s)
+			delete(a.subscribers, s)
+		}
+	}
+	return s, unsubscribe
+}
+
+// emit queues an event to be sent to subscribers once the current event has
+// been processed, so that subscribers never see a Status older than the event.
+func (a *Alarm) emit(e Event) {
+	a.pending = append(a.pending, e)
+}
+
+func (a *Alarm) publish() {
+	a.m.Lock()
+	defer a.m.Unlock()
+	for _, e := range a.pending {
+		for s := range a.subscribers {
+			select {
+			case s <- e:
+			default:
+				a.Logger.Warn("Subscriber is not keeping up, dropped event", logging.F("event", fmt.Sprintf("%T", e)))
+			}
+		}
+	}
+	a.pending = nil
+}
+
+// do runs f on the event loop and waits for it to complete.
+func (a *Alarm) do(f func()) {
+	done := make(chan struct{})
+	select {
+	case a.ops <- op{f: f, done: done}:
+		<-done
+	case <-a.quit:
+	}
+}
 
-	// Used to cancel timers.
-	m             sync.Mutex
-	cancellations []func()
+// after runs f on the event loop once d has elapsed, unless the alarm has
+// changed state in the meantime.
+func (a *Alarm) after(d time.Duration, f func()) {
+	generation := a.generation
+	time.AfterFunc(d, func() {
+		expired := func() {
+			if a.generation != generation {
+				return
+			}
+			f()
+		}
+		select {
+		case a.ops <- op{f: expired}:
+		case <-a.quit:
+		}
+	})
+}
 
-	// Default timer.
-	Timeout func(ctx context.Context)
+// Close stops the event loop. Any pending timers are cancelled.
+func (a *Alarm) Close() {
+	a.once.Do(func() {
+		close(a.quit)
+	})
+	<-a.stopped
+}
 
-	Logger func(format string, v ...interface{})
+// Status returns the current status of the alarm.
+func (a *Alarm) Status() Status {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return a.status
 }
 
 // KeyPressed is an event on the alarm.
 func (a *Alarm) KeyPressed(key string) {
+	a.do(func() {
+		a.keyPressed(key)
+	})
+}
+
+func (a *Alarm) keyPressed(key string) {
 	if key == "*" {
 		a.MediumBeep()
 		a.backspace()
-		a.Display = a.buffer
+		a.showKeys()
 		return
 	}
 	if isDigit(key) {
@@ -109,135 +441,305 @@ func (a *Alarm) KeyPressed(key string) {
 		a.HighBeep()
 	}
 	if key == "C" {
-		a.Logger("Clearing buffer")
+		a.Logger.Debug("Clearing buffer")
 		a.buffer = ""
-		a.Display = a.buffer
+		a.showKeys()
 		return
 	}
 	a.buffer += key
-	a.Display = a.buffer
+	a.showKeys()
 	if key == "#" {
-		a.Logger("Attempting to execute command")
+		a.Logger.Debug("Attempting to execute command")
 		a.MediumBeep()
 		a.executeCommand()
 		a.buffer = ""
 	}
 }
 
-var alarmChangeRegexp = regexp.MustCompile(`B(\d+)B(\d+)#`)
+// showKeys shows the pressed keys on the display.
+func (a *Alarm) showKeys() {
+	a.display = a.buffer
+	a.keysDisplay = a.buffer
+}
+
+var (
+	armRegexp         = regexp.MustCompile(`^(A+)(\d*)#$`)
+	disarmRegexp      = regexp.MustCompile(`^D(\d*)#$`)
+	manageUsersRegexp = regexp.MustCompile(`^B(\d+)([ABD])(\d+)#$`)
+)
 
 func (a *Alarm) executeCommand() {
 	// Examine the buffer for correct values.
-	if strings.HasPrefix(a.buffer, "A") {
-		// Arm the alarm.
-		if a.buffer == "A"+a.Code+"#" {
-			a.Logger("Arming the alarm")
-			a.Arming()
+	if m := armRegexp.FindStringSubmatch(a.buffer); m != nil {
+		// Arm the alarm. A is Away, AA is Home and AAA is Night.
+		mode := Mode(len(m[1]) - 1)
+		if _, ok := ModeNames[mode]; !ok {
+			return
+		}
+		a.Logger.Info("Arming the alarm", logging.F(logging.KeyMode, ModeNames[mode]), logging.F(logging.KeySource, CauseKeypad))
+		if err := a.execute(Command{State: Arming, Mode: mode, Code: m[2]}, CauseKeypad); err != nil {
+			a.Logger.Warn("Failed to arm the alarm", logging.Err(err), logging.F(logging.KeySource, CauseKeypad))
 		}
 		return
 	}
-	if alarmChangeRegexp.MatchString(a.buffer) && a.State == Disarmed {
-		m := alarmChangeRegexp.FindStringSubmatch(a.buffer)
-		firstCode := m[1]
-		if firstCode != a.Code {
-			a.Logger("The entered code %v was not correct", firstCode)
+	if m := manageUsersRegexp.FindStringSubmatch(a.buffer); m != nil && a.state == Disarmed {
+		if err := a.manageUsers(m[1], m[2], m[3]); err != nil {
+			a.Logger.Warn("Failed to manage users", logging.Err(err), logging.F(logging.KeySource, CauseKeypad))
 			return
 		}
-		secondCode := m[2]
-		a.Code = secondCode
-		a.Logger("Changed the alarm code to %v", a.Code)
 		a.LowBeep()
 		a.MediumBeep()
 		a.HighBeep()
 		return
 	}
-	if strings.HasPrefix(a.buffer, "D"+a.Code+"#") {
-		a.Logger("Disarming")
-		a.Disarm()
+	if m := disarmRegexp.FindStringSubmatch(a.buffer); m != nil {
+		a.Logger.Info("Disarming", logging.F(logging.KeySource, CauseKeypad))
+		if err := a.execute(Command{State: Disarmed, Code: m[1]}, CauseKeypad); err != nil {
+			a.Logger.Warn("Failed to disarm the alarm", logging.Err(err), logging.F(logging.KeySource, CauseKeypad))
+		}
 		return
 	}
 }
 
+// Execute a command, if the command's code is correct.
+func (a *Alarm) Execute(c Command) (err error) {
+	a.do(func() {
+		err = a.execute(c, CauseRemote)
+		a.emit(RemoteCommand{State: c.State, Mode: c.Mode, Err: err, Time: time.Now()})
+	})
+	return
+}
+
+func (a *Alarm) execute(c Command, cause Cause) error {
+	u, err := a.checkCode(c.Code, cause)
+	if err != nil {
+		return err
+	}
+	if u.Role == RoleDuress {
+		// The command is carried out as normal, so that nobody watching can
+		// tell that an alert has been raised.
+		a.emit(Duress{User: u.Name, State: c.State, Time: time.Now()})
+	}
+	if !a.canTransition(c.State) {
+		a.Logger.Warn("Invalid transition", logging.F("to", StateNames[c.State]), logging.F(logging.KeyState, StateNames[a.state]), logging.F(logging.KeyUser, u.Name), logging.F(logging.KeySource, cause))
+		return ErrInvalidTransition
+	}
+	a.user = u.Name
+	defer func() {
+		a.user = ""
+	}()
+	switch c.State {
+	case Armed:
+		a.mode = c.Mode
+		a.arm(cause)
+	case Arming:
+		a.arming(c.Mode, cause)
+	case Disarmed:
+		if a.state == Disarmed && a.memory != nil {
+			// Entering the code again acknowledges the alarm memory.
+			a.acknowledgeMemory()
+			return nil
+		}
+		a.disarm(cause)
+	case Triggering:
+		a.triggering(a.EntryDelay, cause)
+	case Triggered:
+		a.trigger(cause)
+	}
+	return nil
+}
+
+// canTransition returns true if a command can change the alarm from its
+// current state to s. Disarming is always allowed.
+func (a *Alarm) canTransition(s State) bool {
+	switch s {
+	case Arming:
+		return a.state == Disarmed
+	case Armed:
+		return a.state == Disarmed || a.state == Arming
+	case Triggering:
+		return a.state == Armed
+	case Triggered:
+		return a.state != Triggered
+	}
+	return true
+}
+
+// checkCode returns the user with the code, if the code is valid. Incorrect
+// codes are counted, and lock out further attempts or trigger the alarm
+// depending on the LockoutAfter and TriggerAfter settings.
+func (a *Alarm) checkCode(code string, cause Cause) (u User, err error) {
+	now := time.Now()
+	if now.Before(a.lockedUntil) {
+		return u, ErrLockedOut
+	}
+	err = ErrBadCode
+	for _, candidate := range a.users {
+		if !candidate.hasCode(code) {
+			continue
+		}
+		if !candidate.ValidAt(now) {
+			a.Logger.Warn("Code used outside of its schedule", logging.F(logging.KeyUser, candidate.Name), logging.F(logging.KeySource, cause))
+			err = ErrCodeNotValid
+			break
+		}
+		if a.failures > 0 {
+			a.failures = 0
+			a.lockouts = 0
+			a.save()
+		}
+		return candidate, nil
+	}
+	a.failures++
+	a.Logger.Warn("Incorrect code entered", logging.F("failures", a.failures), logging.F(logging.KeySource, cause))
+	a.emit(CodeRejected{Failures: a.failures, Cause: cause, Time: now})
+	if a.TriggerAfter > 0 && a.failures >= a.TriggerAfter && a.state == Triggering {
+		a.Logger.Warn("Triggering alarm due to too many incorrect codes", logging.F("failures", a.failures))
+		a.trigger(cause)
+	}
+	if a.LockoutAfter > 0 && a.failures%a.LockoutAfter == 0 {
+		// Double the lockout each time, up to 64 times the initial delay.
+		delay := a.LockoutDelay << uint(a.lockouts)
+		if a.lockouts < 6 {
+			a.lockouts++
+		}
+		a.lockedUntil = now.Add(delay)
+		a.Logger.Warn("Locked out", logging.F("failures", a.failures), logging.F("delay", delay))
+		a.emit(Lockout{Failures: a.failures, Until: a.lockedUntil, Time: now})
+	}
+	a.save()
+	return u, err
+}
+
+// setState moves the alarm to a new state, cancelling any timers.
+func (a *Alarm) setState(s State, cause Cause) {
+	from := a.state
+	a.state = s
+	a.generation++
+	a.emit(Transition{From: from, To: s, Mode: a.mode, Cause: cause, User: a.user, Time: time.Now()})
+	a.save()
+}
+
 // Disarm the alarm.
 func (a *Alarm) Disarm() {
-	a.m.Lock()
-	defer a.m.Unlock()
+	a.do(func() {
+		a.disarm(CauseRemote)
+	})
+}
 
-	// Cancel the timers.
-	for _, cancel := range a.cancellations {
-		cancel()
-	}
-	a.cancellations = nil
+func (a *Alarm) disarm(cause Cause) {
 	// Stop the alarm.
 	a.StopAlarm()
-	a.State = Disarmed
-	a.Logger("Alarm disarmed")
+	a.alarmZones = nil
+	a.clearRearmBypasses()
+	a.setState(Disarmed, cause)
+	a.Logger.Info("Alarm disarmed", logging.F(logging.KeyUser, a.user), logging.F(logging.KeySource, cause))
 	a.LowBeep()
 	a.MediumBeep()
 	a.HighBeep()
-	go a.clearDisplayAfter(time.Second * 5)
+	a.clearDisplayAfter(time.Second * 5)
+}
+
+// Arm the alarm immediately.
+func (a *Alarm) Arm(mode Mode) {
+	a.do(func() {
+		a.mode = mode
+		a.arm(CauseRemote)
+	})
 }
 
-// Arm the alarm.
-func (a *Alarm) Arm() {
-	a.State = Armed
-	a.Logger("Armed")
-	go a.clearDisplayAfter(time.Second * 5)
+func (a *Alarm) arm(cause Cause) {
+	a.setState(Armed, cause)
+	a.Logger.Info("Armed", logging.F(logging.KeyMode, ModeNames[a.mode]), logging.F(logging.KeySource, cause))
+	a.clearDisplayAfter(time.Second * 5)
 }
 
 func (a *Alarm) clearDisplayAfter(d time.Duration) {
-	ctx, cancel := context.WithCancel(context.Background())
-	a.cancellations = append(a.cancellations, cancel)
-	select {
-	case <-time.After(d):
-		a.Display = ""
-	case <-ctx.Done():
-		return
+	a.after(d, func() {
+		a.display = a.idleDisplay()
+	})
+}
+
+// startCountdown displays the seconds remaining, beeping every second, or
+// twice a second during the final Warning period, then calls then. The
+// countdown is cancelled if the state changes.
+func (a *Alarm) startCountdown(d time.Duration, then func(cause Cause)) {
+	var tick func(remaining time.Duration)
+	tick = func(remaining time.Duration) {
+		if remaining <= 0 {
+			a.display = "0"
+			a.LowBeep()
+			a.MediumBeep()
+			a.HighBeep()
+			a.display = a.idleDisplay()
+			then(CauseTimer)
+			return
+		}
+		a.display = fmt.Sprintf("%d", int(math.Ceil(remaining.Seconds())))
+		interval := a.tick
+		if remaining <= a.Warning {
+			interval /= 2
+		}
+		if interval > remaining {
+			interval = remaining
+		}
+		a.after(interval, func() {
+			a.LowBeep()
+			tick(remaining - interval)
+		})
 	}
+	tick(d)
 }
 
 // Arming starts the arming process.
-func (a *Alarm) Arming() {
-	if a.State != Disarmed {
-		a.Logger("Attempted to arm while state was not disarmed, current state is %v", a.State)
+func (a *Alarm) Arming(mode Mode) {
+	a.do(func() {
+		a.arming(mode, CauseRemote)
+	})
+}
+
+func (a *Alarm) arming(mode Mode, cause Cause) {
+	if a.state != Disarmed {
+		a.Logger.Warn("Attempted to arm while not disarmed", logging.F(logging.KeyState, StateNames[a.state]))
 		return
 	}
-	a.State = Arming
-	ctx, cancel := context.WithCancel(context.Background())
-	a.cancellations = append(a.cancellations, cancel)
-	go func() {
-		a.Timeout(ctx)
-		if ctx.Err() == context.Canceled {
-			a.Logger("Alarm arming cancelled")
-			return
-		}
-		a.Arm()
-	}()
-	return
+	a.mode = mode
+	// Arming the alarm acknowledges the alarm memory.
+	a.memory = nil
+	a.alarmZones = nil
+	a.setState(Arming, cause)
+	a.startCountdown(a.ExitDelay, a.arm)
 }
 
-// Triggering the alarm.
+// Triggering starts the entry delay, after which the alarm is triggered.
 func (a *Alarm) Triggering() {
-	a.Logger("Triggering alarm")
-	a.State = Triggering
-	ctx, cancel := context.WithCancel(context.Background())
-	a.cancellations = append(a.cancellations, cancel)
-	go func() {
-		a.Timeout(ctx)
-		if ctx.Err() == context.Canceled {
-			a.Logger("Alarm triggering cancelled")
-			return
-		}
-		a.Trigger()
-	}()
-	return
+	a.do(func() {
+		a.triggering(a.EntryDelay, CauseRemote)
+	})
+}
+
+func (a *Alarm) triggering(entryDelay time.Duration, cause Cause) {
+	a.Logger.Warn("Triggering alarm", logging.F("delay", entryDelay), logging.F(logging.KeySource, cause))
+	a.setState(Triggering, cause)
+	a.startCountdown(entryDelay, a.trigger)
 }
 
 // Trigger the alarm.
 func (a *Alarm) Trigger() {
-	a.Logger("Alarm triggered")
-	a.State = Triggered
+	a.do(func() {
+		a.trigger(CauseRemote)
+	})
+}
+
+func (a *Alarm) trigger(cause Cause) {
+	a.Logger.Error("Alarm triggered", logging.F(logging.KeySource, cause))
+	a.remember()
+	a.triggeredFrom = a.state
+	a.setState(Triggered, cause)
 	a.StartAlarm()
+	if a.SirenDuration > 0 {
+		a.after(a.SirenDuration, a.stopSiren)
+	}
 }
 
 func (a *Alarm) backspace() {
@@ -268,17 +770,3 @@ func isLetter(key string) bool {
 	}
 	return false
 }
-
-// SetDoorIsOpen is used to set whether the door is open or not.
-func (a *Alarm) SetDoorIsOpen(open bool) {
-	if a.doorIsOpen == open {
-		// No change.
-		return
-	}
-	a.doorIsOpen = open
-	if a.State == Armed && a.doorIsOpen {
-		a.Logger("Triggering alarm due to door open")
-		a.Triggering()
-	}
-	return
-}
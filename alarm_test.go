@@ -1,9 +1,18 @@
 package alarm
 
 import (
+	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
+func init() {
+	// Keep the tests fast.
+	hashCost = bcrypt.MinCost
+}
+
 func TestButtons(t *testing.T) {
 	actualCode := "1234"
 	tests := []struct {
@@ -78,12 +87,33 @@ func TestButtons(t *testing.T) {
 			expectedStopAlarms: 1,
 			expectedState:      Disarmed,
 		},
+		{
+			name:              "entering an incorrect code counts as a failure",
+			inputs:            "D4321#",
+			startState:        Triggering,
+			expectedHighBeeps: 1,
+			expectedLowBeeps:  4,
+			expectedMedBeeps:  1,
+			expectedBuffer:    "",
+			expectedFailures:  1,
+			expectedState:     Triggering,
+		},
+		{
+			name:              "entering the correct code resets the failures",
+			inputs:            "A4321#A1234#",
+			expectedHighBeeps: 2,
+			expectedLowBeeps:  8,
+			expectedMedBeeps:  2,
+			expectedBuffer:    "",
+			expectedFailures:  0,
+			expectedState:     Arming,
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			var actualLowBeeps, actualMedBeeps, actualHighBeeps, actualStopAlarms int
 			alarm := New(actualCode)
-			alarm.State = test.startState
+			alarm.state = test.startState
 			alarm.LowBeep = func() {
 				actualLowBeeps++
 			}
@@ -99,8 +129,10 @@ func TestButtons(t *testing.T) {
 			for _, key := range test.inputs {
 				alarm.KeyPressed(string(key))
 			}
-			if alarm.State != test.expectedState {
-				t.Errorf("expected state: %v, got %v", test.expectedState, alarm.State)
+			status := alarm.Status()
+			alarm.Close()
+			if status.State != test.expectedState {
+				t.Errorf("expected state: %v, got %v", test.expectedState, status.State)
 			}
 			if alarm.buffer != test.expectedBuffer {
 				t.Errorf("expected buffer: %q, got %q", test.expectedBuffer, alarm.buffer)
@@ -114,8 +146,8 @@ func TestButtons(t *testing.T) {
 			if actualHighBeeps != test.expectedHighBeeps {
 				t.Errorf("expected high beeps: %d, got %d", test.expectedHighBeeps, actualHighBeeps)
 			}
-			if alarm.Failures != test.expectedFailures {
-				t.Errorf("expected failures: %d, got: %d", test.expectedFailures, alarm.Failures)
+			if status.Failures != test.expectedFailures {
+				t.Errorf("expected failures: %d, got: %d", test.expectedFailures, status.Failures)
 			}
 			if actualStopAlarms != test.expectedStopAlarms {
 				t.Errorf("expected alarm stops: %d, got: %d", test.expectedStopAlarms, actualStopAlarms)
@@ -154,7 +186,7 @@ func TestDoorOpened(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			var actualAlarmStarts, actualAlarmStops int
 			alarm := New("1234")
-			alarm.State = test.start
+			alarm.state = test.start
 			alarm.StartAlarm = func() {
 				actualAlarmStarts++
 			}
@@ -164,8 +196,10 @@ func TestDoorOpened(t *testing.T) {
 			for _, doorState := range test.inputs {
 				alarm.SetDoorIsOpen(doorState)
 			}
-			if alarm.State != test.expectedState {
-				t.Errorf("expected state: %v, got %v", test.expectedState, alarm.State)
+			status := alarm.Status()
+			alarm.Close()
+			if status.State != test.expectedState {
+				t.Errorf("expected state: %v, got %v", test.expectedState, status.State)
 			}
 			if actualAlarmStarts != test.expectedAlarmStarts {
 				t.Errorf("expected alarm starts: %v, got %v", test.expectedAlarmStarts, actualAlarmStarts)
@@ -179,16 +213,263 @@ func TestDoorOpened(t *testing.T) {
 
 func TestAlarmCodeChange(t *testing.T) {
 	alarm := New("1234")
+	defer alarm.Close()
 	for _, k := range "B4321B4321#" {
 		alarm.KeyPressed(string(k))
 	}
-	if alarm.Code != "1234" {
+	if !alarm.users[0].hasCode("1234") {
 		t.Errorf("should not possible to change the code without entering the correct code first")
 	}
 	for _, k := range "B1234B4321#" {
 		alarm.KeyPressed(string(k))
 	}
-	if alarm.Code != "4321" {
+	if !alarm.users[0].hasCode("4321") {
 		t.Errorf("expected the sequence of keys to change the code")
 	}
 }
+
+func TestLockout(t *testing.T) {
+	alarm := New("1234")
+	alarm.LockoutAfter = 2
+	alarm.LockoutDelay = time.Millisecond * 50
+	events, unsubscribe := alarm.Subscribe()
+	defer unsubscribe()
+	defer alarm.Close()
+
+	if err := alarm.Execute(Command{State: Arming, Code: "0000"}); err != ErrBadCode {
+		t.Errorf("expected bad code error, got %v", err)
+	}
+	if err := alarm.Execute(Command{State: Arming, Code: "0000"}); err != ErrBadCode {
+		t.Errorf("expected bad code error, got %v", err)
+	}
+	if err := alarm.Execute(Command{State: Arming, Code: "1234"}); err != ErrLockedOut {
+		t.Errorf("expected the correct code to be rejected during the lockout, got %v", err)
+	}
+	if state := alarm.Status().State; state != Disarmed {
+		t.Errorf("expected state: %v, got %v", Disarmed, state)
+	}
+	var lockout Lockout
+	for e := range events {
+		if l, ok := e.(Lockout); ok {
+			lockout = l
+			break
+		}
+	}
+	if lockout.Failures != 2 {
+		t.Errorf("expected a lockout after 2 failures, got %+v", lockout)
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	if err := alarm.Execute(Command{State: Arming, Code: "1234"}); err != nil {
+		t.Errorf("expected the correct code to be accepted after the lockout, got %v", err)
+	}
+	if status := alarm.Status(); status.State != Arming || status.Failures != 0 {
+		t.Errorf("expected the alarm to be arming with no failures, got %+v", status)
+	}
+}
+
+func TestTriggerAfterIncorrectCodes(t *testing.T) {
+	var actualAlarmStarts int
+	alarm := New("1234")
+	alarm.state = Triggering
+	alarm.TriggerAfter = 2
+	alarm.StartAlarm = func() {
+		actualAlarmStarts++
+	}
+	for _, k := range "D1111#D2222#" {
+		alarm.KeyPressed(string(k))
+	}
+	status := alarm.Status()
+	alarm.Close()
+	if status.State != Triggered {
+		t.Errorf("expected state: %v, got %v", Triggered, status.State)
+	}
+	if actualAlarmStarts != 1 {
+		t.Errorf("expected alarm starts: 1, got %d", actualAlarmStarts)
+	}
+}
+
+func TestArmingModes(t *testing.T) {
+	tests := []struct {
+		inputs       string
+		expectedMode Mode
+	}{
+		{inputs: "A1234#", expectedMode: Away},
+		{inputs: "AA1234#", expectedMode: Home},
+		{inputs: "AAA1234#", expectedMode: Night},
+	}
+	for _, test := range tests {
+		t.Run(test.inputs, func(t *testing.T) {
+			alarm := New("1234")
+			defer alarm.Close()
+			for _, k := range test.inputs {
+				alarm.KeyPressed(string(k))
+			}
+			status := alarm.Status()
+			if status.State != Arming {
+				t.Errorf("expected state: %v, got %v", Arming, status.State)
+			}
+			if status.Mode != test.expectedMode {
+				t.Errorf("expected mode: %v, got %v", test.expectedMode, status.Mode)
+			}
+		})
+	}
+}
+
+func TestArmingCompletesAfterCountdown(t *testing.T) {
+	alarm := New("1234")
+	defer alarm.Close()
+	alarm.ExitDelay = time.Millisecond * 5
+	alarm.Arming(Away)
+	time.Sleep(time.Millisecond * 100)
+	if state := alarm.Status().State; state != Armed {
+		t.Errorf("expected state: %v, got %v", Armed, state)
+	}
+}
+
+func TestShowingKeys(t *testing.T) {
+	alarm := New("1234")
+	defer alarm.Close()
+	alarm.LockoutAfter = 0
+	for _, k := range "A99" {
+		alarm.KeyPressed(string(k))
+	}
+	if s := alarm.Status(); !s.ShowingKeys || s.Display != "A99" {
+		t.Errorf("expected the keys to be shown, got %q, showing keys: %v", s.Display, s.ShowingKeys)
+	}
+	// The keys are still shown after an incorrect code.
+	alarm.KeyPressed("#")
+	if s := alarm.Status(); !s.ShowingKeys {
+		t.Errorf("expected the keys to be shown after an incorrect code, got %q", s.Display)
+	}
+	// The countdown isn't the keys.
+	for _, k := range "A1234#" {
+		alarm.KeyPressed(string(k))
+	}
+	if s := alarm.Status(); s.State != Arming || s.ShowingKeys {
+		t.Errorf("expected the countdown to be shown, got %q, showing keys: %v", s.Display, s.ShowingKeys)
+	}
+}
+
+func TestDisarmCancelsArming(t *testing.T) {
+	alarm := New("1234")
+	defer alarm.Close()
+	alarm.ExitDelay = time.Millisecond * 5
+	alarm.Arming(Away)
+	alarm.Disarm()
+	time.Sleep(time.Millisecond * 100)
+	if state := alarm.Status().State; state != Disarmed {
+		t.Errorf("expected a disarmed alarm to stay disarmed, got %v", state)
+	}
+}
+
+func TestInvalidTransitions(t *testing.T) {
+	tests := []struct {
+		from     State
+		command  State
+		expected error
+	}{
+		{from: Disarmed, command: Arming, expected: nil},
+		{from: Arming, command: Arming, expected: ErrInvalidTransition},
+		{from: Armed, command: Arming, expected: ErrInvalidTransition},
+		{from: Arming, command: Armed, expected: nil},
+		{from: Armed, command: Armed, expected: ErrInvalidTransition},
+		{from: Triggered, command: Armed, expected: ErrInvalidTransition},
+		{from: Disarmed, command: Triggering, expected: ErrInvalidTransition},
+		{from: Armed, command: Triggering, expected: nil},
+		{from: Disarmed, command: Triggered, expected: nil},
+		{from: Triggered, command: Triggered, expected: ErrInvalidTransition},
+		{from: Triggered, command: Disarmed, expected: nil},
+		{from: Disarmed, command: Disarmed, expected: nil},
+	}
+	for _, test := range tests {
+		t.Run(StateNames[test.from]+" to "+StateNames[test.command], func(t *testing.T) {
+			alarm := New("1234")
+			defer alarm.Close()
+			alarm.state = test.from
+			if err := alarm.Execute(Command{State: test.command, Code: "1234"}); err != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, err)
+			}
+			expectedState := test.from
+			if test.expected == nil {
+				expectedState = test.command
+			}
+			if state := alarm.Status().State; state != expectedState {
+				t.Errorf("expected state: %v, got %v", StateNames[expectedState], StateNames[state])
+			}
+		})
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	alarm := New("1234")
+	events, unsubscribe := alarm.Subscribe()
+	defer unsubscribe()
+	for _, k := range "A1234#" {
+		alarm.KeyPressed(string(k))
+	}
+	alarm.Close()
+
+	var transitions []Transition
+	var displayed []string
+	for e := range events {
+		switch e := e.(type) {
+		case Transition:
+			transitions = append(transitions, e)
+		case DisplayChanged:
+			displayed = append(displayed, e.Display)
+		}
+	}
+	if len(transitions) != 1 {
+		t.Fatalf("expected 1 transition, got %d", len(transitions))
+	}
+	if transitions[0].From != Disarmed || transitions[0].To != Arming || transitions[0].Cause != CauseKeypad {
+		t.Errorf("expected transition from disarmed to arming by keypad, got %+v", transitions[0])
+	}
+	expectedDisplayed := []string{"A", "A1", "A12", "A123", "A1234", "30"}
+	if strings.Join(displayed, ",") != strings.Join(expectedDisplayed, ",") {
+		t.Errorf("expected displays %v, got %v", expectedDisplayed, displayed)
+	}
+}
+
+func TestCountdownBeepsFasterDuringWarning(t *testing.T) {
+	var actualLowBeeps int
+	alarm := New("1234")
+	alarm.LowBeep = func() {
+		actualLowBeeps++
+	}
+	alarm.tick = time.Millisecond * 10
+	alarm.ExitDelay = time.Millisecond * 50
+	alarm.Warning = time.Millisecond * 20
+	alarm.Arming(Away)
+	time.Sleep(time.Millisecond * 200)
+	status := alarm.Status()
+	alarm.Close()
+	if status.State != Armed {
+		t.Errorf("expected state: %v, got %v", Armed, status.State)
+	}
+	// A beep every 10ms for the first 30ms, then every 5ms for the last 20ms,
+	// then a final beep.
+	if actualLowBeeps != 8 {
+		t.Errorf("expected low beeps: 8, got %d", actualLowBeeps)
+	}
+}
+
+func TestSirenDuration(t *testing.T) {
+	var actualAlarmStops int
+	alarm := New("1234")
+	alarm.StopAlarm = func() {
+		actualAlarmStops++
+	}
+	alarm.SirenDuration = time.Millisecond * 5
+	alarm.Trigger()
+	time.Sleep(time.Millisecond * 100)
+	status := alarm.Status()
+	alarm.Close()
+	if actualAlarmStops != 1 {
+		t.Errorf("expected the siren to be stopped, got %d alarm stops", actualAlarmStops)
+	}
+	if status.State != Triggered {
+		t.Errorf("expected state: %v, got %v", Triggered, status.State)
+	}
+}
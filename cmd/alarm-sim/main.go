@@ -0,0 +1,212 @@
+// Command alarm-sim runs the alarm in a terminal, with the keyboard as its
+// keypad, so that keypad flows and the Home Assistant integration can be tried
+// without a Pi.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/a-h/alarm"
+	"github.com/a-h/alarm/config"
+	"github.com/a-h/alarm/hardware"
+	"github.com/a-h/alarm/iot"
+	"github.com/a-h/alarm/logging"
+)
+
+var configFlag = flag.String("config", "", "Path to a JSON config file for the zones, delays, MQTT settings and users. The pins are ignored.")
+var codeFlag = flag.String("code", "1234", "The admin code, if the config file has no users.")
+var mqttFlag = flag.Bool("mqtt", false, "Connect to an MQTT broker, set with the same creds.json file and ALARM_MQTT_ environment variables as the alarm.")
+var clientIDFlag = flag.String("client-id", "alarm_sim", "The MQTT client ID, which is different to the alarm's, so that both can be added to Home Assistant. Overrides the config file and environment variables.")
+var topicPrefixFlag = flag.String("topic-prefix", "", "The start of the MQTT topics, which defaults to the client ID, so that the alarm's topics aren't overwritten. Overrides the config file and environment variables.")
+var logFlag = flag.String("log", "alarm-sim.log", "Path to the log file, since the terminal is used by the simulator.")
+var logLevelFlag = flag.String("log-level", "debug", "The lowest level of message to log: debug, info, warn or error.")
+
+// maxEvents is the number of recent events shown.
+const maxEvents = 8
+
+// beepDuration is how long the beep indicator is shown for each beep.
+const beepDuration = time.Millisecond * 150
+
+const (
+	ctrlC  = 3
+	ctrlD  = 4
+	escape = 0x1b
+)
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() (err error) {
+	cfg := config.Default()
+	if *configFlag != "" {
+		if cfg, err = config.Load(*configFlag); err != nil {
+			return err
+		}
+	}
+	level, err := logging.ParseLevel(*logLevelFlag)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(*logFlag, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open the log: %w", err)
+	}
+	defer f.Close()
+	logger := logging.New(f, logging.Options{Level: level})
+
+	code := *codeFlag
+	if len(cfg.Users) > 0 {
+		code = ""
+	}
+	a := alarm.New(code)
+	defer a.Close()
+	a.Logger = logger
+	zones := make([]string, len(cfg.Zones))
+	for i, z := range cfg.Zones {
+		zones[i] = z.Name
+	}
+	fake := hardware.NewFake(zones...)
+	devices := fake.Devices()
+	devices.Attach(a)
+	if err = cfg.Apply(a); err != nil {
+		return err
+	}
+	events, unsubscribe := a.Subscribe()
+	defer unsubscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := screen{}
+	var notifiers alarm.Notifiers
+	if *mqttFlag {
+		opts := iot.DefaultOptions()
+		opts.Logger = logger.With(logging.F(logging.KeySource, "mqtt"))
+		cfg.MQTT.Apply(&opts)
+		if err = opts.ApplyEnv(); err != nil {
+			return err
+		}
+		// The config file and environment variables may be the alarm's, so the
+		// simulator's own client ID and topics are set last.
+		opts.ClientID = *clientIDFlag
+		opts.TopicPrefix = *topicPrefixFlag
+		if opts.TopicPrefix == "" {
+			opts.TopicPrefix = opts.ClientID
+		}
+		bridge, err := iot.New(opts)
+		if err != nil {
+			return err
+		}
+		if err = bridge.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start MQTT: %w", err)
+		}
+		defer bridge.Close()
+		status := a.Status()
+		bridge.PublishStatus(status)
+		for _, z := range status.Zones {
+			bridge.PublishZone(z)
+		}
+		notifiers = append(notifiers, bridge)
+		a.Control(bridge)
+		s.mqtt = "client " + opts.ClientID + ", topics start with " + opts.TopicPrefix
+	}
+
+	restore, err := rawMode(int(os.Stdin.Fd()))
+	if err != nil {
+		return fmt.Errorf("failed to read the keyboard: %w", err)
+	}
+	defer restore()
+	// Hide the cursor while the simulator runs.
+	fmt.Print("\x1b[?25l\x1b[2J")
+	defer fmt.Print("\x1b[?25h\r\n")
+
+	go devices.Run(ctx, a)
+	keys := make(chan byte)
+	go readKeys(os.Stdin, keys)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	ticker := time.NewTicker(time.Millisecond * 50)
+	defer ticker.Stop()
+
+	var beeps int
+	var beepUntil time.Time
+	for {
+		select {
+		case <-sigs:
+			return nil
+		case k, ok := <-keys:
+			if !ok || k == ctrlC || k == ctrlD {
+				return nil
+			}
+			press(fake, cfg.Zones, k)
+		case e := <-events:
+			notifiers.Notify(e, a.Status())
+			if d := describe(e); d != "" {
+				s.events = append(s.events, d)
+				if len(s.events) > maxEvents {
+					s.events = s.events[1:]
+				}
+			}
+		case <-ticker.C:
+		}
+		if n := len(fake.Buzzer.Beeps()); n != beeps {
+			beeps = n
+			beepUntil = time.Now().Add(beepDuration)
+		}
+		s.status = a.Status()
+		s.display = fake.Display.Text()
+		s.beeping = time.Now().Before(beepUntil)
+		s.siren = fake.Siren.Sounding()
+		s.render(os.Stdout)
+	}
+}
+
+// readKeys sends each key read from r, until r is closed.
+func readKeys(r io.Reader, keys chan<- byte) {
+	defer close(keys)
+	buf := make([]byte, 32)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		// Escape sequences, such as the arrow keys, are ignored, since they
+		// would otherwise press the keypad's A to D keys.
+		if n > 0 && buf[0] == escape {
+			continue
+		}
+		for _, k := range buf[:n] {
+			keys <- k
+		}
+	}
+}
+
+// press the keypad key, or toggle the zone, that the key is mapped to.
+func press(fake *hardware.Fake, zones []config.Zone, k byte) {
+	switch {
+	case k >= '0' && k <= '9', k >= 'A' && k <= 'D', k == '*', k == '#':
+		fake.Keypad.Press(string(k))
+	case k >= 'a' && k <= 'd':
+		fake.Keypad.Press(strings.ToUpper(string(k)))
+	case k == '\r' || k == '\n':
+		fake.Keypad.Press("#")
+	default:
+		if i := strings.IndexByte(zoneKeys, k); i >= 0 && i < len(zones) {
+			sensor := fake.Sensors[zones[i].Name]
+			sensor.SetOpen(!sensor.Open())
+		}
+	}
+}
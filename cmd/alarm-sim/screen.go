@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/a-h/alarm"
+)
+
+// segments are lit for each character, in the order a, b, c, d, e, f, g and
+// dp, as on the Pi's seven segment display. Characters that aren't listed are
+// blank on the display.
+var segments = map[rune][8]bool{
+	'1': {false, true, true, false, false, false, false, false},
+	'2': {true, true, false, true, true, false, true, false},
+	'3': {true, true, true, true, false, false, true, false},
+	'4': {false, true, true, false, false, true, true, false},
+	'5': {true, false, true, true, false, true, true, false},
+	'6': {true, false, true, true, true, true, true, false},
+	'7': {true, true, true, false, false, false, false, false},
+	'8': {true, true, true, true, true, true, true, false},
+	'9': {true, true, true, true, false, true, true, false},
+	'0': {true, true, true, true, true, true, false, false},
+	'A': {true, true, true, false, true, true, true, false},
+	'B': {false, false, true, true, true, true, true, false},
+	'C': {true, false, false, true, true, true, false, false},
+	'D': {false, true, true, true, true, false, true, false},
+	'E': {true, false, false, true, true, true, true, false},
+	'F': {true, false, false, false, true, true, true, false},
+	'G': {true, true, true, true, false, true, true, false},
+	'H': {false, false, true, false, true, true, true, false},
+	'I': {false, true, true, false, false, false, false, false},
+	'J': {false, true, true, true, true, false, false, false},
+	'K': {true, false, true, false, true, true, true, false},
+	'L': {false, false, false, true, true, true, false, false},
+	'M': {true, true, true, false, true, true, false, true},
+	'N': {false, false, true, false, true, false, true, false},
+	'O': {false, false, true, true, true, false, true, false},
+	'P': {true, true, false, false, true, true, true, false},
+	'Q': {true, true, true, false, false, true, true, false},
+	'R': {false, false, false, false, true, false, true, false},
+	'S': {true, false, true, true, false, true, true, false},
+	'T': {true, true, true, false, false, false, false, false},
+	'U': {false, false, true, true, true, false, false, false},
+	'V': {false, true, true, true, true, true, false, true},
+	'W': {false, true, true, true, true, true, false, false},
+	'X': {false, true, true, false, true, true, true, true},
+	'Y': {false, true, true, true, false, true, true, false},
+	'Z': {true, true, false, true, true, false, true, true},
+	'.': {false, false, false, false, false, false, false, true},
+	'-': {false, false, false, false, false, false, true, false},
+	'#': {true, true, true, true, true, true, true, true},
+}
+
+// sevenSegment draws the first four characters of s as three lines of a seven
+// segment display.
+func sevenSegment(s string) (lines [3]string) {
+	s = (strings.ToUpper(strings.TrimSpace(s)) + "    ")[:4]
+	lit := func(on bool, c string) string {
+		if on {
+			return c
+		}
+		return " "
+	}
+	for _, r := range s {
+		seg := segments[r]
+		lines[0] += " " + lit(seg[0], "_") + "  "
+		lines[1] += lit(seg[5], "|") + lit(seg[6], "_") + lit(seg[1], "|") + " "
+		lines[2] += lit(seg[4], "|") + lit(seg[3], "_") + lit(seg[2], "|") + lit(seg[7], ".")
+	}
+	return
+}
+
+// zoneKeys toggle each zone, in the order that the zones are configured.
+const zoneKeys = "qwertyuiop"
+
+// screen is what the simulator shows.
+type screen struct {
+	status  alarm.Status
+	display string
+	beeping bool
+	siren   bool
+	mqtt    string
+	events  []string
+}
+
+const (
+	clearToEndOfLine   = "\x1b[K"
+	clearToEndOfScreen = "\x1b[J"
+	home               = "\x1b[H"
+	reverse            = "\x1b[7m"
+	reset              = "\x1b[0m"
+)
+
+// render the screen, overwriting the previous render.
+func (s screen) render(w io.Writer) {
+	var b strings.Builder
+	line := func(format string, a ...interface{}) {
+		b.WriteString(fmt.Sprintf(format, a...) + clearToEndOfLine + "\r\n")
+	}
+	indicator := func(on bool, name string) string {
+		if on {
+			return reverse + " " + name + " " + reset
+		}
+		return " " + strings.Repeat(".", len(name)) + " "
+	}
+
+	b.WriteString(home)
+	line("Alarm simulator")
+	line("")
+	for _, l := range sevenSegment(s.display) {
+		line("   %s", l)
+	}
+	line("")
+	line("   %s %s", indicator(s.beeping, "BEEP"), indicator(s.siren, "SIREN"))
+	line("")
+	state := alarm.StateNames[s.status.State]
+	if s.status.State != alarm.Disarmed {
+		state += " (" + alarm.ModeNames[s.status.Mode] + ")"
+	}
+	line("State:    %s", state)
+	line("Failures: %d", s.status.Failures)
+	if time.Now().Before(s.status.LockedUntil) {
+		line("Locked:   until %s", s.status.LockedUntil.Format("15:04:05"))
+	}
+	if s.mqtt != "" {
+		line("MQTT:     %s", s.mqtt)
+	}
+	line("")
+	line("Zones:")
+	for i, z := range s.status.Zones {
+		key := " "
+		if i < len(zoneKeys) {
+			key = string(zoneKeys[i])
+		}
+		open := "closed"
+		if z.Open {
+			open = "OPEN"
+		}
+		if z.Bypassed {
+			open += ", bypassed"
+		}
+		line("  [%s] %-16s %s", key, z.Name, open)
+	}
+	line("")
+	line("Events:")
+	for _, e := range s.events {
+		line("  %s", e)
+	}
+	line("")
+	line("Keys: 0-9, A-D, * and # (Enter) press the keypad. q-p toggle the zones. Ctrl+C quits.")
+	b.WriteString(clearToEndOfScreen)
+	io.WriteString(w, b.String())
+}
+
+// describe returns a line for the events list, or an empty string if the event
+// isn't listed.
+func describe(e alarm.Event) string {
+	var s string
+	var at time.Time
+	switch e := e.(type) {
+	case alarm.Transition:
+		s = fmt.Sprintf("%s -> %s (%s", alarm.StateNames[e.From], alarm.StateNames[e.To], e.Cause)
+		if e.User != "" {
+			s += ", " + e.User
+		}
+		s += ")"
+		at = e.Time
+	case alarm.ZoneChanged:
+		s = fmt.Sprintf("Zone %q open: %v", e.Zone.Name, e.Zone.Open)
+		at = e.Time
+	case alarm.CodeRejected:
+		s = fmt.Sprintf("Incorrect code (%s), %d consecutive failures", e.Cause, e.Failures)
+		at = e.Time
+	case alarm.Lockout:
+		s = fmt.Sprintf("Locked out until %s", e.Until.Format("15:04:05"))
+		at = e.Time
+	case alarm.RemoteCommand:
+		s = fmt.Sprintf("Remote command to %s", alarm.StateNames[e.State])
+		if e.Err != nil {
+			s += fmt.Sprintf(" failed: %v", e.Err)
+		}
+		at = e.Time
+	case alarm.UserChanged:
+		s = fmt.Sprintf("User %q changed", e.Name)
+		at = e.Time
+	case alarm.Duress:
+		s = fmt.Sprintf("Duress code used by %q", e.User)
+		at = e.Time
+	case alarm.SirenStopped:
+		s = "Siren stopped"
+		at = e.Time
+	default:
+		return ""
+	}
+	return at.Format("15:04:05") + " " + s
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package main
+
+import "errors"
+
+func rawMode(fd int) (restore func() error, err error) {
+	return nil, errors.New("the simulator needs a Unix terminal")
+}
@@ -1,232 +1,313 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"flag"
 	"fmt"
-	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"os/user"
-	"sync"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/a-h/alarm/config"
+	"github.com/a-h/alarm/hardware"
+	"github.com/a-h/alarm/homekit"
 	"github.com/a-h/alarm/iot"
-	"github.com/a-h/segment"
+	"github.com/a-h/alarm/journal"
+	"github.com/a-h/alarm/logging"
+	"github.com/a-h/alarm/web"
 
 	"github.com/a-h/alarm"
-	"github.com/a-h/beeper"
-	"github.com/a-h/keypad"
 	"github.com/stianeikeland/go-rpio"
 )
 
+var configFlag = flag.String("config", "", "Path to a JSON file that configures the pins, zones, delays, MQTT and initial users. The original board's wiring is used if it's not set.")
+var dataFlag = flag.String("data", "alarm.json", "Path to the file used to save the alarm between restarts.")
+var journalFlag = flag.String("journal", "journal.jsonl", "Path to the journal of the alarm's events. Older events are moved to files with numbered suffixes.")
+var exitDelayFlag = flag.Duration("exit-delay", time.Second*30, "How long there is to leave after arming the alarm. Overrides the config file.")
+var entryDelayFlag = flag.Duration("entry-delay", time.Second*30, "How long there is to disarm the alarm after opening an entry/exit zone. Overrides the config file.")
+var sirenDurationFlag = flag.Duration("siren-duration", time.Minute*15, "How long the siren sounds for once triggered, or 0 to sound until disarmed. Overrides the config file.")
+var homeKitPinFlag = flag.String("homekit-pin", "", "The 8 digit pin used to pair with HomeKit. HomeKit is disabled if it's not set. The ALARM_HOMEKIT_CODE environment variable must be set to the alarm code used by HomeKit.")
+var homeKitStorageFlag = flag.String("homekit-storage", "homekit", "Path to the directory used to store HomeKit pairings.")
+var httpFlag = flag.String("http", "", "Address to serve the REST API and keypad page on, e.g. :8080. Disabled if empty.")
+var rearmFlag = flag.Bool("rearm", false, "Re-arm the alarm once the siren stops, instead of showing the alarm memory until it's acknowledged. Overrides the config file.")
+var logLevelFlag = flag.String("log-level", "info", "The lowest level of message to log: debug, info, warn or error.")
+var logJSONFlag = flag.Bool("log-json", false, "Log each message as a line of JSON, e.g. for shipping to journald.")
+var hashCodeFlag = flag.Bool("hash-code", false, "Read a code from stdin, print its hash for a user in the config file, and exit.")
+var fakeHardwareFlag = flag.Bool("fake-hardware", false, "Run without a Pi, using fake devices. The alarm can be used with the web keypad, MQTT or HomeKit.")
+
 func main() {
-	var err error
-	var u *user.User
-	u, err = user.Current()
+	flag.Parse()
+	if *hashCodeFlag {
+		if err := hashCode(); err != nil {
+			fmt.Printf("error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	level, err := logging.ParseLevel(*logLevelFlag)
 	if err != nil {
-		log.Fatalf("Couldn't check if user is running as root: %v", err)
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
 	}
-	if u.Uid != "0" {
-		log.Fatalf("The buzzer requires that the app is ran as root in order to use the PWM feature.")
+	logger := logging.New(os.Stderr, logging.Options{Level: level, JSON: *logJSONFlag})
+	fatal := func(msg string, fields ...logging.Field) {
+		logger.Error(msg, fields...)
+		os.Exit(1)
+	}
+
+	cfg := config.Default()
+	if *configFlag != "" {
+		if cfg, err = config.Load(*configFlag); err != nil {
+			fatal("Invalid config file", logging.F("path", *configFlag), logging.Err(err))
+		}
+	}
+	// Flags that are set override the config file.
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "exit-delay":
+			cfg.ExitDelay = config.Duration(*exitDelayFlag)
+		case "entry-delay":
+			cfg.EntryDelay = config.Duration(*entryDelayFlag)
+		case "siren-duration":
+			cfg.SirenDuration = config.Duration(*sirenDurationFlag)
+		case "rearm":
+			cfg.Rearm = *rearmFlag
+		}
+	})
+	if err = cfg.Validate(); err != nil {
+		fatal("Invalid config", logging.Err(err))
 	}
 
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	err = rpio.Open()
-	if err != nil {
-		fmt.Printf("error: %v\n", err)
-		os.Exit(1)
+	// The initial admin code and the users in the config file are only used
+	// until users have been saved.
+	logger.Info("Creating alarm...")
+	code := os.Getenv("ALARM_CODE")
+	if len(cfg.Users) > 0 && code != "" {
+		logger.Warn("Ignoring the ALARM_CODE environment variable, since the config file has users")
+		code = ""
 	}
-	defer rpio.Close()
-
-	// Setup the keypad.
-	p1 := rpio.Pin(4)
-	p2 := rpio.Pin(17)
-	p3 := rpio.Pin(27)
-	p4 := rpio.Pin(22)
-	p5 := rpio.Pin(18)
-	p6 := rpio.Pin(23)
-	p7 := rpio.Pin(24)
-	p8 := rpio.Pin(25)
-
-	log.Printf("Starting keypad...")
-	pad := keypad.New(p1, p2, p3, p4, p5, p6, p7, p8)
-
-	// Setup the display.
-	// Row 1 (top row of pins).
-	pD1 := rpio.Pin(8)
-	pa := rpio.Pin(7)
-	pf := rpio.Pin(16)
-	pD2 := rpio.Pin(20)
-	pD3 := rpio.Pin(26)
-	pb := rpio.Pin(19)
-	// Row 2 (bottom row of pins).
-	pe := rpio.Pin(13)
-	pd := rpio.Pin(6)
-	pdp := rpio.Pin(5)
-	pc := rpio.Pin(0)
-	pg := rpio.Pin(11)
-	pD4 := rpio.Pin(9)
-
-	disp := segment.NewFourDigitSevenSegmentDisplay(pD1, pa, pf, pD2, pD3, pb, pe, pd, pdp, pc, pg, pD4)
-
-	log.Printf("Creating alarm...")
-	a := alarm.New("0654")
-
-	// Setup the buzzer.
-	log.Printf("Setting up buzzer...")
-	buzzer := rpio.Pin(12)
-	a.HighBeep = func() {
-		beeper.Beep(buzzer, 880.00, time.Millisecond*50)
-	}
-	a.MediumBeep = func() {
-		beeper.Beep(buzzer, 329.00, time.Millisecond*50)
-	}
-	a.LowBeep = func() {
-		beeper.Beep(buzzer, 110.00, time.Millisecond*50)
+	a := alarm.New(code)
+	defer a.Close()
+	a.Logger = logger
+
+	var devices hardware.Devices
+	if *fakeHardwareFlag {
+		logger.Info("Using fake hardware...")
+		names := make([]string, len(cfg.Zones))
+		for i, z := range cfg.Zones {
+			names[i] = z.Name
+		}
+		devices = hardware.NewFake(names...).Devices()
+	} else {
+		var u *user.User
+		u, err = user.Current()
+		if err != nil {
+			fatal("Couldn't check if user is running as root", logging.Err(err))
+		}
+		if u.Uid != "0" {
+			fatal("The buzzer requires that the app is ran as root in order to use the PWM feature.")
+		}
+		if err = rpio.Open(); err != nil {
+			fatal("Failed to open the GPIO pins", logging.Err(err))
+		}
+		defer rpio.Close()
+		logger.Info("Setting up the keypad, display, buzzer and sensors...")
+		devices = piDevices(ctx, cfg)
 	}
+	devices.Attach(a)
 	a.LowBeep()
 	a.MediumBeep()
 	a.HighBeep()
 
-	// Also use the buzzer for the alarm.
-	log.Printf("Setting up alarm buzzer...")
-	as := alarmSounder{
-		Pin: buzzer,
+	// Configure the settings, zones and initial users.
+	if err = cfg.Apply(a); err != nil {
+		fatal("Failed to configure the alarm", logging.Err(err))
+	}
+
+	// Restore the alarm to how it was before the restart.
+	if err = a.Restore(alarm.FileStore{Path: *dataFlag}); err != nil {
+		fatal("Failed to restore the alarm, set the ALARM_CODE environment variable to create an admin user", logging.F("path", *dataFlag), logging.Err(err))
+	}
+
+	// Read the sensors once the alarm is restored, so that a zone that was
+	// opened during a power cut is noticed.
+	if err = devices.ReadSensors(a); err != nil {
+		fatal("Failed to read the sensors", logging.Err(err))
+	}
+	for _, z := range a.Status().Zones {
+		logger.Info("Zone initial state", logging.F(logging.KeyZone, z.Name), logging.F("open", z.Open))
 	}
-	a.StartAlarm = as.Start
-	a.StopAlarm = as.Stop
-	go as.Run()
 
-	// Configure logging.
-	a.Logger = log.Printf
+	// Record the alarm's events.
+	j, err := journal.Open(*journalFlag)
+	if err != nil {
+		fatal("Failed to open the journal", logging.F("path", *journalFlag), logging.Err(err))
+	}
+	defer j.Close()
+	j.Logger = logger.With(logging.F(logging.KeySource, "journal"))
+	if err = j.Write(journal.Entry{Type: journal.TypePowerUp, Message: alarm.StateNames[a.Status().State]}); err != nil {
+		logger.Error("Failed to write to the journal", logging.Err(err))
+	}
 
-	// Configure the reed switch.
-	s := Debounce(rpio.Pin(21))
-	doorState, _ := s()
-	log.Printf("Door initially open: %v", doorState == rpio.High)
-	a.SetDoorIsOpen(doorState == rpio.High)
+	// Subscribe to changes before connecting, so that no changes are missed.
+	events, unsubscribe := a.Subscribe()
+	defer unsubscribe()
 
 	// Create the IoT connection.
-	controlAlarmFromIoT := make(chan alarm.State, 10)
-	updateStateFromDevice, updateDoorIsOpenFromDevice, closer, err := iot.New(controlAlarmFromIoT, &a.Code)
+	mqttOptions := iot.DefaultOptions()
+	mqttOptions.Logger = logger.With(logging.F(logging.KeySource, "mqtt"))
+	cfg.MQTT.Apply(&mqttOptions)
+	if err = mqttOptions.ApplyEnv(); err != nil {
+		fatal("Failed to configure IoT", logging.Err(err))
+	}
+	bridge, err := iot.New(mqttOptions)
 	if err != nil {
-		log.Fatalf("failed to connect to IoT: %v", err)
+		fatal("Failed to create IoT bridge", logging.Err(err))
+	}
+	// The bridge connects in the background, so the alarm runs while the
+	// broker is down.
+	if err = bridge.Start(ctx); err != nil {
+		fatal("Failed to start IoT", logging.Err(err))
 	}
 
 	// Send an initial status to IoT.
-	log.Printf("Setting initial IoT status")
-	updateStateFromDevice <- a.State
-	updateDoorIsOpenFromDevice <- doorState == rpio.High
-	log.Printf("Set initial IoT status complete")
+	logger.Info("Setting initial IoT status")
+	status := a.Status()
+	bridge.PublishStatus(status)
+	for _, z := range status.Zones {
+		bridge.PublishZone(z)
+	}
+	logger.Info("Set initial IoT status complete")
+
+	// Send changes to each integration, and carry out their commands.
+	notifiers := alarm.Notifiers{j, bridge}
+	a.Control(bridge)
 
-	displaying := a.Display
-	alarmState := a.State
+	if *homeKitPinFlag != "" {
+		logger.Info("Starting HomeKit...")
+		homeKitOptions := homekit.DefaultOptions()
+		homeKitOptions.Pin = *homeKitPinFlag
+		homeKitOptions.StoragePath = *homeKitStorageFlag
+		homeKitOptions.Code = os.Getenv("ALARM_HOMEKIT_CODE")
+		homeKitOptions.Logger = logger.With(logging.F(logging.KeySource, "homekit"))
+		hk, err := homekit.New(a.Status(), homeKitOptions)
+		if err != nil {
+			fatal("Failed to create HomeKit accessory", logging.Err(err))
+		}
+		if err = hk.Start(ctx); err != nil {
+			fatal("Failed to start HomeKit", logging.Err(err))
+		}
+		defer hk.Close()
+		notifiers = append(notifiers, hk)
+		a.Control(hk)
+	}
+
+	if *httpFlag != "" {
+		logger.Info("Starting web server...", logging.F("addr", *httpFlag))
+		server, err := web.New(a, web.Options{
+			Token:   os.Getenv("ALARM_HTTP_TOKEN"),
+			Code:    os.Getenv("ALARM_HTTP_CODE"),
+			Journal: j,
+			Logger:  logger.With(logging.F(logging.KeySource, "web")),
+		})
+		if err != nil {
+			fatal("Failed to create web server", logging.Err(err))
+		}
+		hs := &http.Server{Addr: *httpFlag, Handler: server}
+		go func() {
+			if err := hs.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("Web server failed", logging.Err(err))
+			}
+		}()
+		defer hs.Close()
+	}
+
+	// Pass key presses and sensor changes to the alarm, and show its display.
+	go devices.Run(ctx, a)
 
 exit:
 	for {
 		select {
 		case sig := <-sigs:
-			log.Printf("Shutdown signal received; %v", sig)
+			logger.Info("Shutdown signal received", logging.F("signal", sig))
 			break exit
-		case newStatusFromIoT := <-controlAlarmFromIoT:
-			log.Printf("Received control alarm from IoT: %v", newStatusFromIoT)
-			switch newStatusFromIoT {
-			case alarm.Armed:
-				a.Arm()
-			case alarm.Disarmed:
-				a.Disarm()
-			case alarm.Arming:
-				a.Arming()
-			case alarm.Triggered:
-				a.Trigger()
-			}
-		default:
-			if keys, ok := pad.Read(); ok {
-				for _, k := range keys {
-					log.Printf("Key pressed: %v", k)
-					a.KeyPressed(k)
-				}
-			}
-
-			// If the door state has changed, send a notification.
-			var doorStateUpdated bool
-			if doorState, doorStateUpdated = s(); doorStateUpdated {
-				log.Printf("Door open: %v", doorState == rpio.High)
-				a.SetDoorIsOpen(doorState == rpio.High)
-				updateDoorIsOpenFromDevice <- doorState == rpio.High
-			}
-
-			// If the alarm state has changed, send a notification.
-			if alarmState != a.State {
-				alarmState = a.State
-				updateStateFromDevice <- a.State
+		case e := <-events:
+			notifiers.Notify(e, a.Status())
+			switch e := e.(type) {
+			case alarm.Transition:
+				logger.Info("Alarm state changed", logging.F("from", alarm.StateNames[e.From]), logging.F(logging.KeyState, alarm.StateNames[e.To]), logging.F(logging.KeySource, e.Cause))
+			case alarm.DisplayChanged:
+				// The display isn't logged, since it shows the code as it's typed.
+				logger.Debug("Updating screen")
+			case alarm.Lockout:
+				logger.Warn("Locked out", logging.F("failures", e.Failures), logging.F("until", e.Until))
+			case alarm.ZoneChanged:
+				logger.Info("Zone changed", logging.F(logging.KeyZone, e.Zone.Name), logging.F("open", e.Zone.Open))
 			}
-
-			// Update the display.
-			toDisplay := firstFourCharacters(a.Display)
-			if displaying != toDisplay {
-				log.Printf("Updating screen! %s", toDisplay)
-				displaying = toDisplay
-			}
-			disp.Update(displaying)
-			disp.Render()
+			// Duress events aren't logged, in case the logs can be seen.
 		}
 	}
-	close(updateStateFromDevice)
-	closer()
-	log.Printf("Shutdown complete")
+	// Publish any pending changes before disconnecting.
+	bridge.Close()
+	logger.Info("Shutdown complete")
 }
 
-func firstFourCharacters(s string) string {
-	if len(s) > 4 {
-		return s[len(s)-4:]
-	}
-	return s
-}
+// piDevices returns the devices connected to the Pi's pins. The siren runs
+// until the context is cancelled.
+func piDevices(ctx context.Context, cfg config.Config) hardware.Devices {
+	h := cfg.Hardware
+	c, r := h.Keypad.Columns, h.Keypad.Rows
+	keypad := hardware.NewPiKeypad(rpio.Pin(c[0]), rpio.Pin(c[1]), rpio.Pin(c[2]), rpio.Pin(c[3]),
+		rpio.Pin(r[0]), rpio.Pin(r[1]), rpio.Pin(r[2]), rpio.Pin(r[3]))
 
-type alarmSounder struct {
-	Pin        rpio.Pin
-	isSounding bool
-	m          sync.Mutex
-}
+	d, s := h.Display.Digits, h.Display.Segments
+	display := hardware.NewPiDisplay(rpio.Pin(d[0]), rpio.Pin(s.A), rpio.Pin(s.F), rpio.Pin(d[1]), rpio.Pin(d[2]), rpio.Pin(s.B),
+		rpio.Pin(s.E), rpio.Pin(s.D), rpio.Pin(s.DP), rpio.Pin(s.C), rpio.Pin(s.G), rpio.Pin(d[3]))
 
-func (as *alarmSounder) Start() {
-	as.m.Lock()
-	as.isSounding = true
-	defer as.m.Unlock()
-}
-func (as *alarmSounder) Stop() {
-	as.m.Lock()
-	as.isSounding = false
-	defer as.m.Unlock()
-}
+	// The buzzer is also used for the siren.
+	buzzer := rpio.Pin(h.Buzzer)
+	siren := hardware.NewPiSiren(buzzer)
+	go siren.Run(ctx)
 
-func (as *alarmSounder) Run() {
-	for {
-		if as.isSounding {
-			beeper.Beep(as.Pin, 1000, time.Millisecond*50)
-			time.Sleep(time.Millisecond * 10)
-			beeper.Beep(as.Pin, 1500, time.Millisecond*50)
-			time.Sleep(time.Millisecond * 10)
-			beeper.Beep(as.Pin, 2000, time.Millisecond*50)
-		}
-		time.Sleep(time.Millisecond * 150)
+	// Each sensor is pulled up, so an open reed switch reads high.
+	sensors := make(map[string]hardware.ContactSensor, len(cfg.Zones))
+	for _, z := range cfg.Zones {
+		sensors[z.Name] = hardware.NewPiContactSensor(rpio.Pin(z.Pin))
+	}
+	return hardware.Devices{
+		Keypad:  keypad,
+		Display: display,
+		Buzzer:  hardware.PiBuzzer{Pin: buzzer},
+		Siren:   siren,
+		Sensors: sensors,
 	}
 }
 
-// Debounce a pin.
-func Debounce(pin rpio.Pin) func() (s rpio.State, updated bool) {
-	pin.PullUp()
-	lastChange := time.Now()
-	state := pin.Read()
-	return func() (rpio.State, bool) {
-		if time.Now().Before(lastChange.Add(time.Millisecond * 10)) {
-			return state, false
-		}
-		prev := state
-		state = pin.Read()
-		return state, prev != state
+// hashCode prints the hash of the code read from stdin.
+func hashCode() error {
+	fmt.Fprint(os.Stderr, "Code: ")
+	code, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && code == "" {
+		return fmt.Errorf("failed to read the code: %w", err)
+	}
+	code = strings.TrimSpace(code)
+	if code == "" || strings.Trim(code, "0123456789") != "" {
+		return fmt.Errorf("the code must be made of digits")
+	}
+	hash, err := alarm.HashCode(code)
+	if err != nil {
+		return err
 	}
+	fmt.Println(string(hash))
+	return nil
 }
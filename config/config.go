@@ -0,0 +1,363 @@
+// Package config reads the alarm's wiring, zones, settings and initial users
+// from a JSON file, so that different boards can be built without
+// recompiling.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/a-h/alarm"
+	"github.com/a-h/alarm/iot"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Config of the alarm.
+type Config struct {
+	Hardware Hardware `json:"hardware"`
+	Zones    []Zone   `json:"zones"`
+
+	ExitDelay     Duration `json:"exitDelay"`
+	EntryDelay    Duration `json:"entryDelay"`
+	SirenDuration Duration `json:"sirenDuration"`
+	Rearm         bool     `json:"rearm"`
+	LockoutAfter  int      `json:"lockoutAfter"`
+	LockoutDelay  Duration `json:"lockoutDelay"`
+	TriggerAfter  int      `json:"triggerAfter"`
+
+	MQTT MQTT `json:"mqtt"`
+	// Users are only added when nothing has been saved yet. After that, users
+	// are managed from the keypad.
+	Users []User `json:"users,omitempty"`
+}
+
+// Hardware is the Pi's BCM GPIO pin numbers that each device is connected to.
+type Hardware struct {
+	Keypad  KeypadPins  `json:"keypad"`
+	Display DisplayPins `json:"display"`
+	// Buzzer is also used for the siren.
+	Buzzer int `json:"buzzer"`
+}
+
+// KeypadPins are the pins of a 4x4 matrix keypad.
+type KeypadPins struct {
+	Columns [4]int `json:"columns"`
+	Rows    [4]int `json:"rows"`
+}
+
+// DisplayPins are the pins of a four digit seven segment display.
+type DisplayPins struct {
+	// Digits are the pins of D1 to D4.
+	Digits   [4]int      `json:"digits"`
+	Segments SegmentPins `json:"segments"`
+}
+
+// SegmentPins are the pins of each segment of the display.
+type SegmentPins struct {
+	A  int `json:"a"`
+	B  int `json:"b"`
+	C  int `json:"c"`
+	D  int `json:"d"`
+	E  int `json:"e"`
+	F  int `json:"f"`
+	G  int `json:"g"`
+	DP int `json:"dp"`
+}
+
+// Zone is a contact sensor connected to a pin.
+type Zone struct {
+	Name string `json:"name"`
+	// Type is entry_exit, instant, 24_hour or motion.
+	Type string `json:"type"`
+	Pin  int    `json:"pin"`
+	// EntryDelay overrides the alarm's entry delay for an entry/exit zone.
+	EntryDelay Duration `json:"entryDelay,omitempty"`
+}
+
+// ZoneTypes are the zone types by their name in the config file.
+var ZoneTypes = map[string]alarm.ZoneType{
+	"entry_exit": alarm.EntryExit,
+	"instant":    alarm.Instant,
+	"24_hour":    alarm.TwentyFourHour,
+	"motion":     alarm.Motion,
+}
+
+// MQTT settings override the iot package's defaults. Empty settings are left
+// at their defaults.
+type MQTT struct {
+	CredentialsPath string `json:"credentialsPath,omitempty"`
+	ClientID        string `json:"clientId,omitempty"`
+	TopicPrefix     string `json:"topicPrefix,omitempty"`
+	DiscoveryPrefix string `json:"discoveryPrefix,omitempty"`
+	QoS             *byte  `json:"qos,omitempty"`
+	Retain          *bool  `json:"retain,omitempty"`
+}
+
+// User of the alarm.
+type User struct {
+	Name string `json:"name"`
+	// Role is admin, user, guest or duress.
+	Role string `json:"role"`
+	// Hash is the bcrypt hash of the code entered on the keypad, so that the
+	// code isn't stored in the file. It's printed by the alarm's -hash-code
+	// flag.
+	Hash string `json:"hash"`
+}
+
+// Roles are the user roles by their name in the config file.
+var Roles = map[string]alarm.Role{
+	"admin":  alarm.RoleAdmin,
+	"user":   alarm.RoleUser,
+	"guest":  alarm.RoleGuest,
+	"duress": alarm.RoleDuress,
+}
+
+// Duration is written as a string in the config file, e.g. "30s" or "15m".
+type Duration time.Duration
+
+// MarshalJSON writes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON reads the duration from a string.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string, e.g. \"30s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Default returns the configuration of the original board.
+func Default() Config {
+	return Config{
+		Hardware: Hardware{
+			Keypad: KeypadPins{
+				Columns: [4]int{4, 17, 27, 22},
+				Rows:    [4]int{18, 23, 24, 25},
+			},
+			Display: DisplayPins{
+				Digits: [4]int{8, 20, 26, 9},
+				Segments: SegmentPins{
+					A: 7, B: 19, C: 0, D: 6, E: 13, F: 16, G: 11, DP: 5,
+				},
+			},
+			Buzzer: 12,
+		},
+		Zones: []Zone{
+			{Name: alarm.DoorZone, Type: "entry_exit", Pin: 21},
+		},
+		ExitDelay:     Duration(time.Second * 30),
+		EntryDelay:    Duration(time.Second * 30),
+		SirenDuration: Duration(time.Minute * 15),
+		LockoutAfter:  3,
+		LockoutDelay:  Duration(time.Second * 30),
+	}
+}
+
+// Load the config file. Settings that aren't in the file are left at their
+// defaults.
+func Load(path string) (c Config, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	c = Default()
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err = d.Decode(&c); err != nil {
+		return c, fmt.Errorf("config: failed to read %s: %w", path, err)
+	}
+	return c, c.Validate()
+}
+
+// ValidationError lists the problems with a config.
+type ValidationError []string
+
+func (ve ValidationError) Error() string {
+	return "config: " + strings.Join(ve, "; ")
+}
+
+// maxPin is the highest GPIO pin on the Pi's header.
+const maxPin = 27
+
+// Validate returns a ValidationError if the config can't be used.
+func (c Config) Validate() error {
+	var ve ValidationError
+	problem := func(format string, a ...interface{}) {
+		ve = append(ve, fmt.Sprintf(format, a...))
+	}
+
+	// Each pin can only be used by one device.
+	pins := map[int]string{}
+	pin := func(name string, p int) {
+		if p < 0 || p > maxPin {
+			problem("%s: pin %d is not between 0 and %d", name, p, maxPin)
+			return
+		}
+		if used, ok := pins[p]; ok {
+			problem("%s: pin %d is already used by %s", name, p, used)
+			return
+		}
+		pins[p] = name
+	}
+	h := c.Hardware
+	for i, p := range h.Keypad.Columns {
+		pin(fmt.Sprintf("hardware.keypad.columns[%d]", i), p)
+	}
+	for i, p := range h.Keypad.Rows {
+		pin(fmt.Sprintf("hardware.keypad.rows[%d]", i), p)
+	}
+	for i, p := range h.Display.Digits {
+		pin(fmt.Sprintf("hardware.display.digits[%d]", i), p)
+	}
+	s := h.Display.Segments
+	for _, seg := range []struct {
+		name string
+		pin  int
+	}{{"a", s.A}, {"b", s.B}, {"c", s.C}, {"d", s.D}, {"e", s.E}, {"f", s.F}, {"g", s.G}, {"dp", s.DP}} {
+		pin("hardware.display.segments."+seg.name, seg.pin)
+	}
+	pin("hardware.buzzer", h.Buzzer)
+
+	if len(c.Zones) == 0 {
+		problem("zones: at least one zone is required")
+	}
+	zones := map[string]bool{}
+	// Each zone's MQTT topics are named after it, so they mustn't be the same
+	// as another zone's, or the alarm's.
+	topics := map[string]string{iot.ObjectID("alarm"): "the alarm"}
+	for i, z := range c.Zones {
+		name := fmt.Sprintf("zones[%d]", i)
+		id := iot.ObjectID(z.Name)
+		if z.Name == "" {
+			problem("%s.name: is required", name)
+		} else if zones[z.Name] {
+			problem("%s.name: zone %q already exists", name, z.Name)
+		} else if used, ok := topics[id]; ok {
+			problem("%s.name: zone %q has the same MQTT topics as %s", name, z.Name, used)
+		}
+		zones[z.Name] = true
+		if _, ok := topics[id]; !ok {
+			topics[id] = fmt.Sprintf("zone %q", z.Name)
+		}
+		if _, ok := ZoneTypes[z.Type]; !ok {
+			problem("%s.type: %q is not entry_exit, instant, 24_hour or motion", name, z.Type)
+		}
+		pin(name+".pin", z.Pin)
+		if z.EntryDelay < 0 {
+			problem("%s.entryDelay: must not be negative", name)
+		}
+	}
+
+	for _, d := range []struct {
+		name string
+		d    Duration
+	}{{"exitDelay", c.ExitDelay}, {"entryDelay", c.EntryDelay}, {"sirenDuration", c.SirenDuration}, {"lockoutDelay", c.LockoutDelay}} {
+		if d.d < 0 {
+			problem("%s: must not be negative", d.name)
+		}
+	}
+	if c.LockoutAfter < 0 {
+		problem("lockoutAfter: must not be negative")
+	}
+	if c.TriggerAfter < 0 {
+		problem("triggerAfter: must not be negative")
+	}
+
+	if c.MQTT.QoS != nil && *c.MQTT.QoS > 2 {
+		problem("mqtt.qos: %d is not 0, 1 or 2", *c.MQTT.QoS)
+	}
+
+	users := map[string]bool{}
+	var admins int
+	for i, u := range c.Users {
+		name := fmt.Sprintf("users[%d]", i)
+		if u.Name == "" {
+			problem("%s.name: is required", name)
+		} else if users[u.Name] {
+			problem("%s.name: user %q already exists", name, u.Name)
+		}
+		users[u.Name] = true
+		role, ok := Roles[u.Role]
+		if !ok {
+			problem("%s.role: %q is not admin, user, guest or duress", name, u.Role)
+		}
+		if ok && role == alarm.RoleAdmin {
+			admins++
+		}
+		// The hash isn't included in the problems, since they may be logged.
+		if u.Hash == "" {
+			problem("%s.hash: is required", name)
+		} else if _, err := bcrypt.Cost([]byte(u.Hash)); err != nil {
+			problem("%s.hash: is not a bcrypt hash", name)
+		}
+	}
+	if len(c.Users) > 0 && admins == 0 {
+		problem("users: at least one admin is required")
+	}
+
+	if len(ve) > 0 {
+		return ve
+	}
+	return nil
+}
+
+// Apply the settings, zones and users to a new alarm, before it's restored.
+func (c Config) Apply(a *alarm.Alarm) error {
+	a.ExitDelay = time.Duration(c.ExitDelay)
+	a.EntryDelay = time.Duration(c.EntryDelay)
+	a.SirenDuration = time.Duration(c.SirenDuration)
+	a.Rearm = c.Rearm
+	a.LockoutAfter = c.LockoutAfter
+	a.LockoutDelay = time.Duration(c.LockoutDelay)
+	a.TriggerAfter = c.TriggerAfter
+	for _, z := range c.Zones {
+		err := a.AddZone(alarm.Zone{
+			Name:       z.Name,
+			Type:       ZoneTypes[z.Type],
+			EntryDelay: time.Duration(z.EntryDelay),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, u := range c.Users {
+		if err := a.AddUserWithHash(alarm.User{Name: u.Name, Role: Roles[u.Role], Hash: []byte(u.Hash)}); err != nil {
+			return fmt.Errorf("config: failed to add user %q: %w", u.Name, err)
+		}
+	}
+	return nil
+}
+
+// Apply the MQTT settings that are set to the options.
+func (m MQTT) Apply(o *iot.Options) {
+	if m.CredentialsPath != "" {
+		o.CredentialsPath = m.CredentialsPath
+	}
+	if m.ClientID != "" {
+		o.ClientID = m.ClientID
+	}
+	if m.TopicPrefix != "" {
+		o.TopicPrefix = m.TopicPrefix
+	}
+	if m.DiscoveryPrefix != "" {
+		o.DiscoveryPrefix = m.DiscoveryPrefix
+	}
+	if m.QoS != nil {
+		o.QoS = *m.QoS
+	}
+	if m.Retain != nil {
+		o.Retain = *m.Retain
+	}
+}
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-h/alarm"
+	"github.com/a-h/alarm/iot"
+)
+
+// hash2468 is the bcrypt hash of the code 2468.
+const hash2468 = "$2a$04$K4F.wFW1.K6Y8S7pwCaWQOTtpqxRGoCcpYESrE.VNoabh/qYPM4Te"
+
+func writeConfig(t *testing.T, json string) (path string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "alarm-config")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	path = filepath.Join(dir, "config.json")
+	if err = ioutil.WriteFile(path, []byte(json), 0600); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path, cleanup := writeConfig(t, `{
+		"hardware": {"buzzer": 10},
+		"zones": [
+			{"name": "front door", "type": "entry_exit", "pin": 21, "entryDelay": "45s"},
+			{"name": "hall", "type": "motion", "pin": 20}
+		],
+		"exitDelay": "1m",
+		"display": {},
+		"mqtt": {"topicPrefix": "alarm", "qos": 2}
+	}`)
+	defer cleanup()
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), `unknown field "display"`) {
+		t.Fatalf("expected an unknown field error, got %v", err)
+	}
+
+	path, cleanup = writeConfig(t, `{
+		"hardware": {"buzzer": 10},
+		"zones": [
+			{"name": "front door", "type": "entry_exit", "pin": 21, "entryDelay": "45s"},
+			{"name": "hall", "type": "motion", "pin": 3}
+		],
+		"exitDelay": "1m",
+		"mqtt": {"topicPrefix": "alarm", "qos": 2},
+		"users": [{"name": "admin", "role": "admin", "hash": "`+hash2468+`"}]
+	}`)
+	defer cleanup()
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := Default()
+	expected.Hardware.Buzzer = 10
+	expected.Zones = []Zone{
+		{Name: "front door", Type: "entry_exit", Pin: 21, EntryDelay: Duration(45 * time.Second)},
+		{Name: "hall", Type: "motion", Pin: 3},
+	}
+	expected.ExitDelay = Duration(time.Minute)
+	qos := byte(2)
+	expected.MQTT = MQTT{TopicPrefix: "alarm", QoS: &qos}
+	expected.Users = []User{{Name: "admin", Role: "admin", Hash: hash2468}}
+	if !reflect.DeepEqual(expected, c) {
+		t.Errorf("expected %+v, got %+v", expected, c)
+	}
+
+	opts := iot.DefaultOptions()
+	c.MQTT.Apply(&opts)
+	if opts.TopicPrefix != "alarm" || opts.QoS != 2 || opts.DiscoveryPrefix != "homeassistant" || !opts.Retain {
+		t.Errorf("unexpected MQTT options: %+v", opts)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(c *Config)
+		expected []string
+	}{
+		{
+			name: "pin used twice",
+			change: func(c *Config) {
+				c.Zones[0].Pin = 12
+			},
+			expected: []string{"zones[0].pin: pin 12 is already used by hardware.buzzer"},
+		},
+		{
+			name: "pin out of range",
+			change: func(c *Config) {
+				c.Hardware.Keypad.Rows[1] = 40
+			},
+			expected: []string{"hardware.keypad.rows[1]: pin 40 is not between 0 and 27"},
+		},
+		{
+			name: "invalid zones",
+			change: func(c *Config) {
+				c.Zones = append(c.Zones, Zone{Name: "door", Type: "window", Pin: 2, EntryDelay: -1})
+			},
+			expected: []string{
+				`zones[1].name: zone "door" already exists`,
+				`zones[1].type: "window" is not entry_exit, instant, 24_hour or motion`,
+				"zones[1].entryDelay: must not be negative",
+			},
+		},
+		{
+			name: "zones with the same topics",
+			change: func(c *Config) {
+				c.Zones = append(c.Zones,
+					Zone{Name: "alarm", Type: "instant", Pin: 2},
+					Zone{Name: "back door", Type: "instant", Pin: 3},
+					Zone{Name: "back/door", Type: "instant", Pin: 10},
+				)
+			},
+			expected: []string{
+				`zones[1].name: zone "alarm" has the same MQTT topics as the alarm`,
+				`zones[3].name: zone "back/door" has the same MQTT topics as zone "back door"`,
+			},
+		},
+		{
+			name: "no zones",
+			change: func(c *Config) {
+				c.Zones = nil
+			},
+			expected: []string{"zones: at least one zone is required"},
+		},
+		{
+			name: "negative settings",
+			change: func(c *Config) {
+				c.ExitDelay = -1
+				c.LockoutAfter = -1
+			},
+			expected: []string{"exitDelay: must not be negative", "lockoutAfter: must not be negative"},
+		},
+		{
+			name: "invalid QoS",
+			change: func(c *Config) {
+				qos := byte(3)
+				c.MQTT.QoS = &qos
+			},
+			expected: []string{"mqtt.qos: 3 is not 0, 1 or 2"},
+		},
+		{
+			name: "invalid users",
+			change: func(c *Config) {
+				c.Users = []User{
+					{Name: "alice", Role: "user", Hash: hash2468},
+					{Name: "alice", Role: "owner", Hash: "2468"},
+					{Name: "bob", Role: "guest"},
+				}
+			},
+			expected: []string{
+				`users[1].name: user "alice" already exists`,
+				`users[1].role: "owner" is not admin, user, guest or duress`,
+				"users[1].hash: is not a bcrypt hash",
+				"users[2].hash: is required",
+				"users: at least one admin is required",
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := Default()
+			tc.change(&c)
+			err := c.Validate()
+			ve, ok := err.(ValidationError)
+			if !ok {
+				t.Fatalf("expected a ValidationError, got %v", err)
+			}
+			if !reflect.DeepEqual(ValidationError(tc.expected), ve) {
+				t.Errorf("expected:\n%s\ngot:\n%s", strings.Join(tc.expected, "\n"), strings.Join(ve, "\n"))
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	c := Default()
+	c.Zones = append(c.Zones, Zone{Name: "smoke", Type: "24_hour", Pin: 2})
+	c.TriggerAfter = 5
+	c.Users = []User{{Name: "owner", Role: "admin", Hash: hash2468}}
+	a := alarm.New("")
+	defer a.Close()
+	if err := c.Apply(a); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.ExitDelay != 30*time.Second || a.TriggerAfter != 5 || a.SirenDuration != 15*time.Minute {
+		t.Errorf("unexpected settings: exit delay %v, trigger after %d, siren duration %v", a.ExitDelay, a.TriggerAfter, a.SirenDuration)
+	}
+	zones := a.Status().Zones
+	if len(zones) != 2 || zones[1].Name != "smoke" || zones[1].Type != alarm.TwentyFourHour {
+		t.Errorf("unexpected zones: %+v", zones)
+	}
+	if users := a.Users(); len(users) != 1 || users[0].Name != "owner" || users[0].Role != alarm.RoleAdmin {
+		t.Errorf("unexpected users: %+v", users)
+	}
+	if err := a.Execute(alarm.Command{State: alarm.Arming, Code: "2468"}); err != nil {
+		t.Errorf("expected the user's code to arm the alarm, got %v", err)
+	}
+}
@@ -10,5 +10,6 @@ require (
 	github.com/eclipse/paho.mqtt.golang v1.4.2
 	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/stianeikeland/go-rpio v4.2.0+incompatible
-	golang.org/x/net v0.8.0 // indirect
+	golang.org/x/crypto v0.8.0
+	golang.org/x/sys v0.7.0
 )
@@ -0,0 +1,158 @@
+package hardware
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeKeypad is a keypad whose keys are pressed by calling Press.
+type FakeKeypad struct {
+	m    sync.Mutex
+	keys []string
+}
+
+// Press the keys.
+func (k *FakeKeypad) Press(keys ...string) {
+	k.m.Lock()
+	defer k.m.Unlock()
+	k.keys = append(k.keys, keys...)
+}
+
+// Read returns the keys pressed since the last read.
+func (k *FakeKeypad) Read() (keys []string, ok bool) {
+	k.m.Lock()
+	defer k.m.Unlock()
+	keys, k.keys = k.keys, nil
+	return keys, len(keys) > 0
+}
+
+// FakeDisplay records what's shown on the display.
+type FakeDisplay struct {
+	m sync.Mutex
+	s string
+}
+
+// Update the display.
+func (d *FakeDisplay) Update(s string) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	d.s = s
+}
+
+// Render does nothing, since the text is read with Text.
+func (d *FakeDisplay) Render() {}
+
+// Text returns what's shown on the display.
+func (d *FakeDisplay) Text() string {
+	d.m.Lock()
+	defer d.m.Unlock()
+	return d.s
+}
+
+// FakeBuzzer records the frequency of each beep.
+type FakeBuzzer struct {
+	m     sync.Mutex
+	beeps []int
+}
+
+// Beep records the frequency.
+func (b *FakeBuzzer) Beep(frequency int, duration time.Duration) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.beeps = append(b.beeps, frequency)
+}
+
+// Beeps returns the frequency of each beep so far.
+func (b *FakeBuzzer) Beeps() []int {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return append([]int{}, b.beeps...)
+}
+
+// FakeSiren records whether the siren is sounding.
+type FakeSiren struct {
+	m        sync.Mutex
+	sounding bool
+}
+
+// Start the siren.
+func (s *FakeSiren) Start() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.sounding = true
+}
+
+// Stop the siren.
+func (s *FakeSiren) Stop() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.sounding = false
+}
+
+// Sounding returns true if the siren has been started and not stopped.
+func (s *FakeSiren) Sounding() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.sounding
+}
+
+// FakeContactSensor is a sensor that's opened and closed by calling SetOpen.
+type FakeContactSensor struct {
+	m    sync.Mutex
+	open bool
+}
+
+// SetOpen opens or closes the sensor.
+func (s *FakeContactSensor) SetOpen(open bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.open = open
+}
+
+// Open returns true if the sensor is open.
+func (s *FakeContactSensor) Open() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.open
+}
+
+// Fake devices, which can be controlled and inspected by tests and simulators.
+type Fake struct {
+	Keypad  *FakeKeypad
+	Display *FakeDisplay
+	Buzzer  *FakeBuzzer
+	Siren   *FakeSiren
+	Sensors map[string]*FakeContactSensor
+}
+
+// NewFake creates fake devices with a sensor for each zone.
+func NewFake(zones ...string) *Fake {
+	f := &Fake{
+		Keypad:  &FakeKeypad{},
+		Display: &FakeDisplay{},
+		Buzzer:  &FakeBuzzer{},
+		Siren:   &FakeSiren{},
+		Sensors: make(map[string]*FakeContactSensor, len(zones)),
+	}
+	for _, z := range zones {
+		f.Sensors[z] = &FakeContactSensor{}
+	}
+	return f
+}
+
+// Devices returns the fake devices to attach to the alarm.
+func (f *Fake) Devices() Devices {
+	d := Devices{
+		Keypad:  f.Keypad,
+		Display: f.Display,
+		Buzzer:  f.Buzzer,
+		Siren:   f.Siren,
+		Sensors: make(map[string]ContactSensor, len(f.Sensors)),
+		// There's no need to poll a fake as often as a multiplexed display.
+		PollInterval: time.Millisecond * 10,
+	}
+	for name, s := range f.Sensors {
+		d.Sensors[name] = s
+	}
+	return d
+}
@@ -0,0 +1,133 @@
+// Package hardware connects the alarm to its electronics: a keypad, a display,
+// a buzzer, a siren and the contact sensors of each zone. The Pi devices use
+// go-rpio, and the fakes let the alarm run on any machine.
+package hardware
+
+import (
+	"context"
+	"time"
+
+	"github.com/a-h/alarm"
+)
+
+// Keypad is read for the keys that have been pressed.
+type Keypad interface {
+	// Read returns the keys pressed since the last read.
+	Read() (keys []string, ok bool)
+}
+
+// Display shows the alarm's display.
+type Display interface {
+	Update(s string)
+	// Render the display. It's called continuously, since a multiplexed
+	// display only lights one digit at a time.
+	Render()
+}
+
+// Buzzer beeps when keys are pressed.
+type Buzzer interface {
+	Beep(frequency int, duration time.Duration)
+}
+
+// Siren sounds when the alarm is triggered.
+type Siren interface {
+	Start()
+	Stop()
+}
+
+// ContactSensor reports whether a zone is open, e.g. a reed switch on a door.
+type ContactSensor interface {
+	Open() bool
+}
+
+// Devices are the electronics connected to the alarm.
+type Devices struct {
+	Keypad  Keypad
+	Display Display
+	Buzzer  Buzzer
+	Siren   Siren
+	// Sensors are the contact sensors of each zone, by zone name.
+	Sensors map[string]ContactSensor
+	// PollInterval is the time to wait between reads of the keypad and
+	// sensors. Zero polls continuously, which a multiplexed display needs.
+	PollInterval time.Duration
+}
+
+const beepDuration = time.Millisecond * 50
+
+// Attach sets the alarm's electronics callbacks to use the devices. It must be
+// called before the alarm is used.
+func (d Devices) Attach(a *alarm.Alarm) {
+	a.HighBeep = func() {
+		d.Buzzer.Beep(880, beepDuration)
+	}
+	a.MediumBeep = func() {
+		d.Buzzer.Beep(329, beepDuration)
+	}
+	a.LowBeep = func() {
+		d.Buzzer.Beep(110, beepDuration)
+	}
+	a.StartAlarm = d.Siren.Start
+	a.StopAlarm = d.Siren.Stop
+}
+
+// ReadSensors opens or closes each zone to match its sensor. It's used once the
+// alarm is restored, so that a zone that was opened during a power cut is
+// noticed.
+func (d Devices) ReadSensors(a *alarm.Alarm) error {
+	for name, s := range d.Sensors {
+		if err := a.SetZoneOpen(name, s.Open()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run passes key presses and sensor changes to the alarm, and shows the alarm's
+// display, until the context is cancelled.
+func (d Devices) Run(ctx context.Context, a *alarm.Alarm) {
+	events, unsubscribe := a.Subscribe()
+	defer unsubscribe()
+	status := a.Status()
+	d.Display.Update(firstFourCharacters(status.Display))
+	// Start from the alarm's zones, rather than the sensors, so that a sensor
+	// that changed after ReadSensors is passed to the alarm.
+	open := make(map[string]bool, len(d.Sensors))
+	for _, z := range status.Zones {
+		open[z.Name] = z.Open
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-events:
+			if dc, ok := e.(alarm.DisplayChanged); ok {
+				d.Display.Update(firstFourCharacters(dc.Display))
+			}
+		default:
+			if keys, ok := d.Keypad.Read(); ok {
+				for _, k := range keys {
+					a.KeyPressed(k)
+				}
+			}
+			for name, s := range d.Sensors {
+				if isOpen := s.Open(); isOpen != open[name] {
+					open[name] = isOpen
+					// The zones were checked by ReadSensors.
+					a.SetZoneOpen(name, isOpen)
+				}
+			}
+			d.Display.Render()
+			if d.PollInterval > 0 {
+				time.Sleep(d.PollInterval)
+			}
+		}
+	}
+}
+
+func firstFourCharacters(s string) string {
+	if len(s) > 4 {
+		return s[len(s)-4:]
+	}
+	return s
+}
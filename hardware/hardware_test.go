@@ -0,0 +1,105 @@
+package hardware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/a-h/alarm"
+)
+
+func waitFor(t *testing.T, what string, f func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 5)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestFakeDevices(t *testing.T) {
+	a := alarm.New("1234")
+	defer a.Close()
+	a.ExitDelay = time.Millisecond * 50
+	a.EntryDelay = time.Millisecond * 50
+	a.LockoutAfter = 0
+	fake := NewFake(alarm.DoorZone)
+	d := fake.Devices()
+	d.Attach(a)
+	if err := a.AddZone(alarm.Zone{Name: alarm.DoorZone, Type: alarm.EntryExit}); err != nil {
+		t.Fatalf("failed to add zone: %v", err)
+	}
+	if err := d.ReadSensors(a); err != nil {
+		t.Fatalf("failed to read sensors: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx, a)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Arm the alarm from the keypad, which shows the keys on the display.
+	fake.Keypad.Press("A", "1", "2")
+	waitFor(t, "the display to show the keys", func() bool { return fake.Display.Text() == "A12" })
+	fake.Keypad.Press("3", "4", "#")
+	waitFor(t, "the alarm to arm", func() bool { return a.Status().State == alarm.Armed })
+	if len(fake.Buzzer.Beeps()) == 0 {
+		t.Error("expected the buzzer to beep")
+	}
+
+	// Opening the door triggers the alarm once the entry delay has passed.
+	fake.Sensors[alarm.DoorZone].SetOpen(true)
+	waitFor(t, "the siren to sound", fake.Siren.Sounding)
+	if !a.Status().Zones[0].Open {
+		t.Error("expected the door zone to be open")
+	}
+
+	// Disarming stops the siren.
+	fake.Keypad.Press("D", "1", "2", "3", "4", "#")
+	waitFor(t, "the siren to stop", func() bool { return !fake.Siren.Sounding() })
+	if state := a.Status().State; state != alarm.Disarmed {
+		t.Errorf("expected the alarm to be disarmed, got %v", alarm.StateNames[state])
+	}
+}
+
+func TestRunPassesChangesSinceReadSensors(t *testing.T) {
+	a := alarm.New("1234")
+	defer a.Close()
+	fake := NewFake(alarm.DoorZone)
+	d := fake.Devices()
+	if err := a.AddZone(alarm.Zone{Name: alarm.DoorZone, Type: alarm.EntryExit}); err != nil {
+		t.Fatalf("failed to add zone: %v", err)
+	}
+	if err := d.ReadSensors(a); err != nil {
+		t.Fatalf("failed to read sensors: %v", err)
+	}
+
+	// The door opens before Run starts.
+	fake.Sensors[alarm.DoorZone].SetOpen(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx, a)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	waitFor(t, "the door zone to open", func() bool { return a.Status().Zones[0].Open })
+}
+
+func TestReadSensorsUnknownZone(t *testing.T) {
+	a := alarm.New("1234")
+	defer a.Close()
+	if err := NewFake("garage").Devices().ReadSensors(a); err == nil {
+		t.Error("expected an error for a sensor without a zone")
+	}
+}
@@ -0,0 +1,119 @@
+package hardware
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/a-h/beeper"
+	"github.com/a-h/keypad"
+	"github.com/a-h/segment"
+	"github.com/stianeikeland/go-rpio"
+)
+
+// NewPiKeypad creates a 4x4 matrix keypad connected to the pins.
+func NewPiKeypad(col1, col2, col3, col4, row1, row2, row3, row4 rpio.Pin) Keypad {
+	pad := keypad.New(col1, col2, col3, col4, row1, row2, row3, row4)
+	return &pad
+}
+
+// NewPiDisplay creates a four digit seven segment display connected to the
+// pins.
+func NewPiDisplay(pD1, pa, pf, pD2, pD3, pb, pe, pd, pdp, pc, pg, pD4 rpio.Pin) Display {
+	return segment.NewFourDigitSevenSegmentDisplay(pD1, pa, pf, pD2, pD3, pb, pe, pd, pdp, pc, pg, pD4)
+}
+
+// PiBuzzer is a buzzer driven by PWM, which requires root.
+type PiBuzzer struct {
+	Pin rpio.Pin
+}
+
+// Beep at the frequency.
+func (b PiBuzzer) Beep(frequency int, duration time.Duration) {
+	beeper.Beep(b.Pin, frequency, duration)
+}
+
+// PiSiren sounds a rising tone on a buzzer. Run must be called for it to sound.
+type PiSiren struct {
+	Pin      rpio.Pin
+	m        sync.Mutex
+	sounding bool
+}
+
+// NewPiSiren creates a siren that sounds on the buzzer connected to the pin.
+func NewPiSiren(pin rpio.Pin) *PiSiren {
+	return &PiSiren{Pin: pin}
+}
+
+// Start sounding the siren.
+func (s *PiSiren) Start() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.sounding = true
+}
+
+// Stop sounding the siren.
+func (s *PiSiren) Stop() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.sounding = false
+}
+
+func (s *PiSiren) isSounding() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.sounding
+}
+
+// Run sounds the siren while it's started, until the context is cancelled.
+func (s *PiSiren) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		if s.isSounding() {
+			beeper.Beep(s.Pin, 1000, time.Millisecond*50)
+			time.Sleep(time.Millisecond * 10)
+			beeper.Beep(s.Pin, 1500, time.Millisecond*50)
+			time.Sleep(time.Millisecond * 10)
+			beeper.Beep(s.Pin, 2000, time.Millisecond*50)
+		}
+		time.Sleep(time.Millisecond * 150)
+	}
+}
+
+// debounceInterval is the time a sensor's reading is held after it changes,
+// so that a bouncing reed switch doesn't open and close the zone repeatedly.
+const debounceInterval = time.Millisecond * 10
+
+// PiContactSensor is a reed switch connected to a pin that's pulled up, so
+// that it reads high when the switch is open. It isn't safe for concurrent use.
+type PiContactSensor struct {
+	pin        rpio.Pin
+	state      rpio.State
+	lastChange time.Time
+}
+
+// NewPiContactSensor pulls up the pin, and reads the sensor's initial state.
+func NewPiContactSensor(pin rpio.Pin) *PiContactSensor {
+	pin.PullUp()
+	return &PiContactSensor{
+		pin:        pin,
+		state:      pin.Read(),
+		lastChange: time.Now(),
+	}
+}
+
+// Open returns true if the switch is open.
+func (s *PiContactSensor) Open() bool {
+	if time.Since(s.lastChange) < debounceInterval {
+		return s.state == rpio.High
+	}
+	if state := s.pin.Read(); state != s.state {
+		s.state = state
+		s.lastChange = time.Now()
+	}
+	return s.state == rpio.High
+}
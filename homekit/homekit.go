@@ -0,0 +1,243 @@
+// Package homekit exposes the alarm as a HomeKit security system, so that it
+// can be armed and disarmed from the Home app.
+package homekit
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/a-h/alarm"
+	"github.com/a-h/alarm/logging"
+	"github.com/brutella/hc"
+	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/service"
+)
+
+// Options configure the HomeKit accessory.
+type Options struct {
+	// Name of the security system shown in the Home app.
+	Name string
+	// Pin is the 8 digit code entered in the Home app to pair with the alarm.
+	Pin string
+	// StoragePath is the directory used to store the pairings.
+	StoragePath string
+	// Port to listen on. Defaults to a random port.
+	Port string
+	// Code is the alarm code used for commands from the Home app. It should
+	// belong to a user added for HomeKit, since HomeKit checks who can control
+	// the alarm when it's paired.
+	Code string
+	// Logger receives the errors from commands sent from the Home app.
+	Logger logging.Logger
+}
+
+// DefaultOptions returns the default options. The pin and code must be set.
+func DefaultOptions() Options {
+	return Options{
+		Name:        "Alarm",
+		StoragePath: "homekit",
+		Logger:      logging.Nop{},
+	}
+}
+
+// HomeKit is a HomeKit security system accessory with a contact sensor for
+// each zone. It's an alarm.Notifier, which updates the accessories, and an
+// alarm.Controller, which sends the states chosen in the Home app to the alarm.
+type HomeKit struct {
+	opts     Options
+	security *accessory.Accessory
+	system   *service.SecuritySystem
+	sensors  map[string]*service.ContactSensor
+	// accessories contains the contact sensors, in the order of the zones.
+	accessories []*accessory.Accessory
+	commands    chan alarm.Request
+
+	// m protects the transport and status.
+	m         sync.Mutex
+	transport hc.Transport
+	closed    bool
+	status    alarm.Status
+}
+
+// New creates the accessories for the alarm and each of its zones. Zones
+// added to the alarm later are not shown in HomeKit.
+func New(status alarm.Status, opts Options) (*HomeKit, error) {
+	if opts.Name == "" {
+		return nil, errors.New("homekit: name is required")
+	}
+	if opts.Code == "" {
+		return nil, errors.New("homekit: code is required")
+	}
+	if _, err := hc.ValidatePin(opts.Pin); err != nil {
+		return nil, err
+	}
+	if opts.Logger == nil {
+		opts.Logger = logging.Nop{}
+	}
+	h := &HomeKit{
+		opts:     opts,
+		security: accessory.New(info(opts.Name), accessory.TypeSecuritySystem),
+		system:   service.NewSecuritySystem(),
+		sensors:  map[string]*service.ContactSensor{},
+		commands: make(chan alarm.Request, 10),
+	}
+	h.security.AddService(h.system.Service)
+	h.system.SecuritySystemTargetState.OnValueRemoteUpdate(h.targetStateChanged)
+	for _, z := range status.Zones {
+		sensor := service.NewContactSensor()
+		a := accessory.New(info(z.Name), accessory.TypeSensor)
+		a.AddService(sensor.Service)
+		h.sensors[z.Name] = sensor
+		h.accessories = append(h.accessories, a)
+	}
+	h.update(status)
+	return h, nil
+}
+
+func info(name string) accessory.Info {
+	return accessory.Info{
+		Name:         name,
+		Manufacturer: "a-h",
+		Model:        "github.com/a-h/alarm",
+	}
+}
+
+// Start listening for the Home app until the context is cancelled or the
+// accessory is closed.
+func (h *HomeKit) Start(ctx context.Context) error {
+	h.m.Lock()
+	defer h.m.Unlock()
+	if h.closed || h.transport != nil {
+		return errors.New("homekit: already started or closed")
+	}
+	config := hc.Config{
+		Pin:         h.opts.Pin,
+		StoragePath: h.opts.StoragePath,
+		Port:        h.opts.Port,
+	}
+	t, err := hc.NewIPTransport(config, h.security, h.accessories...)
+	if err != nil {
+		return err
+	}
+	h.transport = t
+	go t.Start()
+	go func() {
+		<-ctx.Done()
+		h.Close()
+	}()
+	return nil
+}
+
+// Close stops listening for the Home app.
+func (h *HomeKit) Close() {
+	h.m.Lock()
+	defer h.m.Unlock()
+	if h.closed {
+		return
+	}
+	h.closed = true
+	if h.transport != nil {
+		<-h.transport.Stop()
+	}
+}
+
+// Commands returns the commands received from the Home app.
+func (h *HomeKit) Commands() <-chan alarm.Request {
+	return h.commands
+}
+
+// Notify updates the accessories with the status after the event.
+func (h *HomeKit) Notify(e alarm.Event, s alarm.Status) {
+	switch e := e.(type) {
+	case alarm.Transition:
+		h.update(s)
+	case alarm.ZoneChanged:
+		h.updateZone(e.Zone)
+	}
+}
+
+func (h *HomeKit) update(s alarm.Status) {
+	h.m.Lock()
+	h.status = s
+	h.m.Unlock()
+	h.system.SecuritySystemCurrentState.SetValue(currentState(s))
+	h.system.SecuritySystemTargetState.SetValue(targetState(s))
+	for _, z := range s.Zones {
+		h.updateZone(z)
+	}
+}
+
+func (h *HomeKit) updateZone(z alarm.Zone) {
+	sensor, ok := h.sensors[z.Name]
+	if !ok {
+		return
+	}
+	if z.Open {
+		sensor.ContactSensorState.SetValue(characteristic.ContactSensorStateContactNotDetected)
+		return
+	}
+	sensor.ContactSensorState.SetValue(characteristic.ContactSensorStateContactDetected)
+}
+
+var modeStates = map[alarm.Mode]int{
+	alarm.Home:  characteristic.SecuritySystemCurrentStateStayArm,
+	alarm.Away:  characteristic.SecuritySystemCurrentStateAwayArm,
+	alarm.Night: characteristic.SecuritySystemCurrentStateNightArm,
+}
+
+// currentState returns the HomeKit state of the alarm. The Home app shows
+// that the alarm is arming while the target state differs from the current
+// state, so the alarm is disarmed until the exit delay has passed, and stays
+// armed during the entry delay.
+func currentState(s alarm.Status) int {
+	switch s.State {
+	case alarm.Armed, alarm.Triggering:
+		return modeStates[s.Mode]
+	case alarm.Triggered:
+		return characteristic.SecuritySystemCurrentStateAlarmTriggered
+	}
+	return characteristic.SecuritySystemCurrentStateDisarmed
+}
+
+// targetState returns the state that the alarm is moving towards.
+func targetState(s alarm.Status) int {
+	if s.State == alarm.Disarmed {
+		return characteristic.SecuritySystemTargetStateDisarm
+	}
+	// The current and target state values for each mode are the same.
+	return modeStates[s.Mode]
+}
+
+var targetModes = map[int]alarm.Mode{
+	characteristic.SecuritySystemTargetStateStayArm:  alarm.Home,
+	characteristic.SecuritySystemTargetStateAwayArm:  alarm.Away,
+	characteristic.SecuritySystemTargetStateNightArm: alarm.Night,
+}
+
+// command returns the command that moves the alarm to the target state.
+// Arming starts the exit delay, since the Home app is often used on the way
+// out.
+func command(target int, code string) alarm.Command {
+	if mode, ok := targetModes[target]; ok {
+		return alarm.Command{State: alarm.Arming, Mode: mode, Code: code}
+	}
+	return alarm.Command{State: alarm.Disarmed, Code: code}
+}
+
+// targetStateChanged runs when the target state is set in the Home app.
+func (h *HomeKit) targetStateChanged(target int) {
+	result := make(chan error, 1)
+	h.commands <- alarm.Request{Command: command(target, h.opts.Code), Result: result}
+	go func() {
+		if err := <-result; err != nil {
+			h.opts.Logger.Warn("HomeKit command failed", logging.Err(err))
+			// Show the actual state in the Home app.
+			h.m.Lock()
+			s := h.status
+			h.m.Unlock()
+			h.system.SecuritySystemTargetState.SetValue(targetState(s))
+		}
+	}()
+}
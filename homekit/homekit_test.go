@@ -0,0 +1,106 @@
+package homekit
+
+import (
+	"testing"
+
+	"github.com/a-h/alarm"
+	"github.com/brutella/hc/characteristic"
+)
+
+func TestStates(t *testing.T) {
+	tests := []struct {
+		name            string
+		status          alarm.Status
+		expectedCurrent int
+		expectedTarget  int
+	}{
+		{
+			name:            "disarmed",
+			status:          alarm.Status{State: alarm.Disarmed, Mode: alarm.Night},
+			expectedCurrent: characteristic.SecuritySystemCurrentStateDisarmed,
+			expectedTarget:  characteristic.SecuritySystemTargetStateDisarm,
+		},
+		{
+			name:            "arming",
+			status:          alarm.Status{State: alarm.Arming, Mode: alarm.Away},
+			expectedCurrent: characteristic.SecuritySystemCurrentStateDisarmed,
+			expectedTarget:  characteristic.SecuritySystemTargetStateAwayArm,
+		},
+		{
+			name:            "armed home",
+			status:          alarm.Status{State: alarm.Armed, Mode: alarm.Home},
+			expectedCurrent: characteristic.SecuritySystemCurrentStateStayArm,
+			expectedTarget:  characteristic.SecuritySystemTargetStateStayArm,
+		},
+		{
+			name:            "triggering",
+			status:          alarm.Status{State: alarm.Triggering, Mode: alarm.Night},
+			expectedCurrent: characteristic.SecuritySystemCurrentStateNightArm,
+			expectedTarget:  characteristic.SecuritySystemTargetStateNightArm,
+		},
+		{
+			name:            "triggered",
+			status:          alarm.Status{State: alarm.Triggered, Mode: alarm.Away},
+			expectedCurrent: characteristic.SecuritySystemCurrentStateAlarmTriggered,
+			expectedTarget:  characteristic.SecuritySystemTargetStateAwayArm,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := currentState(test.status); actual != test.expectedCurrent {
+				t.Errorf("expected current state %d, got %d", test.expectedCurrent, actual)
+			}
+			if actual := targetState(test.status); actual != test.expectedTarget {
+				t.Errorf("expected target state %d, got %d", test.expectedTarget, actual)
+			}
+		})
+	}
+}
+
+func TestCommand(t *testing.T) {
+	tests := []struct {
+		target   int
+		expected alarm.Command
+	}{
+		{target: characteristic.SecuritySystemTargetStateStayArm, expected: alarm.Command{State: alarm.Arming, Mode: alarm.Home, Code: "1234"}},
+		{target: characteristic.SecuritySystemTargetStateAwayArm, expected: alarm.Command{State: alarm.Arming, Mode: alarm.Away, Code: "1234"}},
+		{target: characteristic.SecuritySystemTargetStateNightArm, expected: alarm.Command{State: alarm.Arming, Mode: alarm.Night, Code: "1234"}},
+		{target: characteristic.SecuritySystemTargetStateDisarm, expected: alarm.Command{State: alarm.Disarmed, Code: "1234"}},
+	}
+	for _, test := range tests {
+		if actual := command(test.target, "1234"); actual != test.expected {
+			t.Errorf("target %d: expected %+v, got %+v", test.target, test.expected, actual)
+		}
+	}
+}
+
+func TestNotify(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Pin = "12344321"
+	opts.Code = "1234"
+	status := alarm.Status{Zones: []alarm.Zone{{Name: alarm.DoorZone}}}
+	h, err := New(status, opts)
+	if err != nil {
+		t.Fatalf("failed to create accessory: %v", err)
+	}
+
+	status = alarm.Status{State: alarm.Armed, Mode: alarm.Away, Zones: status.Zones}
+	h.Notify(alarm.Transition{From: alarm.Arming, To: alarm.Armed}, status)
+	if actual := h.system.SecuritySystemCurrentState.GetValue(); actual != characteristic.SecuritySystemCurrentStateAwayArm {
+		t.Errorf("expected the security system to be armed, got %d", actual)
+	}
+
+	h.Notify(alarm.ZoneChanged{Zone: alarm.Zone{Name: alarm.DoorZone, Open: true}}, status)
+	if actual := h.sensors[alarm.DoorZone].ContactSensorState.GetValue(); actual != characteristic.ContactSensorStateContactNotDetected {
+		t.Errorf("expected the door to be open, got %d", actual)
+	}
+}
+
+func TestNewRequiresAPinAndCode(t *testing.T) {
+	if _, err := New(alarm.Status{}, Options{Name: "Alarm", Pin: "12344321"}); err == nil {
+		t.Error("expected an error without a code")
+	}
+	if _, err := New(alarm.Status{}, Options{Name: "Alarm", Code: "1234"}); err == nil {
+		t.Error("expected an error without a pin")
+	}
+}
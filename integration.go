@@ -0,0 +1,59 @@
+package alarm
+
+import "github.com/a-h/alarm/logging"
+
+// Notifier sends the alarm's events to an integration, such as MQTT, a
+// webhook or HomeKit.
+type Notifier interface {
+	// Notify is called with each event, and the status of the alarm after the
+	// event. It should not block for long, since events are sent to each
+	// notifier in turn.
+	Notify(e Event, s Status)
+}
+
+// Controller receives commands for the alarm from an integration.
+type Controller interface {
+	// Commands returns the commands received by the integration.
+	Commands() <-chan Request
+}
+
+// Request is a command received by an integration.
+type Request struct {
+	Command
+	// Result receives the error returned by executing the command. It must be
+	// buffered, so that the alarm isn't held up by the integration.
+	Result chan<- error
+}
+
+// Notifiers sends events to each of the notifiers.
+type Notifiers []Notifier
+
+// Notify each of the notifiers of the event.
+func (notifiers Notifiers) Notify(e Event, s Status) {
+	for _, n := range notifiers {
+		n.Notify(e, s)
+	}
+}
+
+// Control executes the commands received by the controller until its
+// channel is closed or the alarm is closed.
+func (a *Alarm) Control(c Controller) {
+	go func() {
+		commands := c.Commands()
+		for {
+			select {
+			case r, ok := <-commands:
+				if !ok {
+					return
+				}
+				err := a.Execute(r.Command)
+				if err != nil {
+					a.Logger.Warn("Failed to execute command", logging.F("to", StateNames[r.State]), logging.Err(err), logging.F(logging.KeySource, CauseRemote))
+				}
+				r.Result <- err
+			case <-a.quit:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,53 @@
+package alarm
+
+import "testing"
+
+type testController chan Request
+
+func (c testController) Commands() <-chan Request {
+	return c
+}
+
+type testNotifier struct {
+	events []Event
+}
+
+func (n *testNotifier) Notify(e Event, s Status) {
+	n.events = append(n.events, e)
+}
+
+func TestControl(t *testing.T) {
+	alarm := New("1234")
+	defer alarm.Close()
+	commands := make(testController)
+	defer close(commands)
+	alarm.Control(commands)
+
+	tests := []struct {
+		command  Command
+		expected error
+	}{
+		{command: Command{State: Arming, Code: "0000"}, expected: ErrBadCode},
+		{command: Command{State: Arming, Code: "1234"}, expected: nil},
+		{command: Command{State: Arming, Code: "1234"}, expected: ErrInvalidTransition},
+	}
+	for _, test := range tests {
+		result := make(chan error, 1)
+		commands <- Request{Command: test.command, Result: result}
+		if err := <-result; err != test.expected {
+			t.Errorf("%+v: expected %v, got %v", test.command, test.expected, err)
+		}
+	}
+	if state := alarm.Status().State; state != Arming {
+		t.Errorf("expected state: %v, got %v", Arming, state)
+	}
+}
+
+func TestNotifiers(t *testing.T) {
+	a, b := &testNotifier{}, &testNotifier{}
+	notifiers := Notifiers{a, b}
+	notifiers.Notify(Transition{From: Disarmed, To: Arming}, Status{State: Arming})
+	if len(a.events) != 1 || len(b.events) != 1 {
+		t.Errorf("expected each notifier to receive the event, got %v and %v", a.events, b.events)
+	}
+}
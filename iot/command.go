@@ -0,0 +1,82 @@
+package iot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/a-h/alarm"
+	"github.com/a-h/alarm/logging"
+)
+
+// Results published to the result topic.
+const (
+	ResultAccepted          = "accepted"
+	ResultBadCode           = "rejected_bad_code"
+	ResultInvalidTransition = "rejected_invalid_transition"
+	ResultRejected          = "rejected"
+	ResultMalformed         = "malformed"
+)
+
+// CommandResult is published to the result topic for each command received on
+// the control topic.
+type CommandResult struct {
+	// ID is the correlation ID of the command.
+	ID     string `json:"id,omitempty"`
+	Action string `json:"action,omitempty"`
+	Result string `json:"result"`
+	// Error describes why the command was rejected.
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+var actions = map[string]alarm.Command{
+	"ARM_HOME":  {State: alarm.Armed, Mode: alarm.Home},
+	"ARM_AWAY":  {State: alarm.Armed, Mode: alarm.Away},
+	"ARM_NIGHT": {State: alarm.Armed, Mode: alarm.Night},
+	"DISARM":    {State: alarm.Disarmed},
+	"TRIGGER":   {State: alarm.Triggered},
+}
+
+// parseCommand parses a message received on the control topic.
+func parseCommand(payload []byte) (m AlarmMessage, c alarm.Command, err error) {
+	if err = json.Unmarshal(payload, &m); err != nil {
+		return m, c, err
+	}
+	c, ok := actions[m.Action]
+	if !ok {
+		return m, c, fmt.Errorf("unknown action %q", m.Action)
+	}
+	c.Code = m.Code
+	return m, c, nil
+}
+
+// newCommandResult returns the result of executing the command in m.
+func newCommandResult(m AlarmMessage, err error) CommandResult {
+	r := CommandResult{ID: m.ID, Action: m.Action, Result: ResultAccepted, Time: time.Now()}
+	if err == nil {
+		return r
+	}
+	r.Error = err.Error()
+	switch {
+	case errors.Is(err, alarm.ErrBadCode), errors.Is(err, alarm.ErrLockedOut),
+		errors.Is(err, alarm.ErrCodeNotValid), errors.Is(err, alarm.ErrNotAllowed):
+		r.Result = ResultBadCode
+	case errors.Is(err, alarm.ErrInvalidTransition):
+		r.Result = ResultInvalidTransition
+	default:
+		r.Result = ResultRejected
+	}
+	return r
+}
+
+func (p *publisher) publishResult(r CommandResult) {
+	p.opts.Logger.Info("Publishing command result", logging.F("action", r.Action), logging.F("id", r.ID), logging.F("result", r.Result))
+	payload, err := json.Marshal(r)
+	if err != nil {
+		p.opts.Logger.Error("Failed to marshal command result", logging.Err(err))
+		return
+	}
+	p.publish(p.topic("alarm", "result"), p.opts.QoS, string(payload), false)
+}
@@ -0,0 +1,77 @@
+package iot
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/a-h/alarm"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		payload     string
+		expected    alarm.Command
+		expectError bool
+	}{
+		{
+			payload:  `{"id": "1", "action": "ARM_AWAY", "code": "1234"}`,
+			expected: alarm.Command{State: alarm.Armed, Mode: alarm.Away, Code: "1234"},
+		},
+		{
+			payload:  `{"action": "ARM_NIGHT", "code": "1234"}`,
+			expected: alarm.Command{State: alarm.Armed, Mode: alarm.Night, Code: "1234"},
+		},
+		{
+			payload:  `{"action": "DISARM", "code": "1234"}`,
+			expected: alarm.Command{State: alarm.Disarmed, Code: "1234"},
+		},
+		{
+			payload:     `{"action": "ARM_VACATION", "code": "1234"}`,
+			expectError: true,
+		},
+		{
+			payload:     `ARM_AWAY`,
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.payload, func(t *testing.T) {
+			_, actual, err := parseCommand([]byte(test.payload))
+			if test.expectError {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestNewCommandResult(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{err: nil, expected: ResultAccepted},
+		{err: alarm.ErrBadCode, expected: ResultBadCode},
+		{err: alarm.ErrLockedOut, expected: ResultBadCode},
+		{err: alarm.ErrCodeNotValid, expected: ResultBadCode},
+		{err: alarm.ErrInvalidTransition, expected: ResultInvalidTransition},
+		{err: errors.New("other"), expected: ResultRejected},
+	}
+	for _, test := range tests {
+		r := newCommandResult(AlarmMessage{ID: "abc", Action: "ARM_AWAY"}, test.err)
+		if r.Result != test.expected {
+			t.Errorf("%v: expected %q, got %q", test.err, test.expected, r.Result)
+		}
+		if r.ID != "abc" {
+			t.Errorf("%v: expected the correlation ID to be copied, got %q", test.err, r.ID)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package iot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/a-h/alarm"
+	"github.com/a-h/alarm/logging"
+)
+
+// Device is the device that Home Assistant groups the alarm's entities under.
+type Device struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer,omitempty"`
+	Model        string   `json:"model,omitempty"`
+}
+
+// DiscoveryConfig is published to Home Assistant to create an entity.
+type DiscoveryConfig struct {
+	Name              string  `json:"name"`
+	UniqueID          string  `json:"unique_id"`
+	Device            *Device `json:"device"`
+	StateTopic        string  `json:"state_topic"`
+	AvailabilityTopic string  `json:"availability_topic"`
+	DeviceClass       string  `json:"device_class,omitempty"`
+	EntityCategory    string  `json:"entity_category,omitempty"`
+
+	// Alarm control panel settings.
+	CommandTopic       string `json:"command_topic,omitempty"`
+	CommandTemplate    string `json:"command_template,omitempty"`
+	Code               string `json:"code,omitempty"`
+	CodeArmRequired    *bool  `json:"code_arm_required,omitempty"`
+	CodeDisarmRequired *bool  `json:"code_disarm_required,omitempty"`
+
+	// Binary sensor settings.
+	PayloadOn  string `json:"payload_on,omitempty"`
+	PayloadOff string `json:"payload_off,omitempty"`
+}
+
+// deviceID returns an ID that is unique to the machine, so that alarms on
+// different machines don't share a client ID, and can be discovered by the
+// same Home Assistant.
+func deviceID() string {
+	id, err := ioutil.ReadFile("/etc/machine-id")
+	if err != nil || len(strings.TrimSpace(string(id))) == 0 {
+		hostname, _ := os.Hostname()
+		id = []byte(hostname)
+	}
+	hash := sha256.Sum256([]byte(strings.TrimSpace(string(id))))
+	return "alarm_" + hex.EncodeToString(hash[:6])
+}
+
+var zoneDeviceClasses = map[alarm.ZoneType]string{
+	alarm.EntryExit:      "door",
+	alarm.Instant:        "opening",
+	alarm.TwentyFourHour: "safety",
+	alarm.Motion:         "motion",
+}
+
+func newDevice(id string) *Device {
+	return &Device{
+		Identifiers:  []string{id},
+		Name:         "Alarm",
+		Manufacturer: "a-h",
+		Model:        "github.com/a-h/alarm",
+	}
+}
+
+func (p *publisher) publishDiscovery(zones map[string]alarm.Zone) {
+	id := p.opts.ClientID
+	device := newDevice(id)
+	required := true
+	p.publishConfig("alarm_control_panel", "alarm", DiscoveryConfig{
+		Name:               "Alarm",
+		UniqueID:           id + "_alarm",
+		Device:             device,
+		StateTopic:         p.topic("alarm", "contact"),
+		AvailabilityTopic:  p.topic("alarm", "availability"),
+		CommandTopic:       p.topic("alarm", "control"),
+		CommandTemplate:    `{"action": "{{ action }}", "code": "{{ code }}"}`,
+		Code:               "REMOTE_CODE",
+		CodeArmRequired:    &required,
+		CodeDisarmRequired: &required,
+	})
+	p.publishConfig("sensor", "failures", DiscoveryConfig{
+		Name:              "Alarm code failures",
+		UniqueID:          id + "_failures",
+		Device:            device,
+		StateTopic:        p.topic("alarm", "failures"),
+		AvailabilityTopic: p.topic("alarm", "availability"),
+		EntityCategory:    "diagnostic",
+	})
+	p.publishConfig("sensor", "started", DiscoveryConfig{
+		Name:              "Alarm started",
+		UniqueID:          id + "_started",
+		Device:            device,
+		StateTopic:        p.topic("alarm", "started"),
+		AvailabilityTopic: p.topic("alarm", "availability"),
+		DeviceClass:       "timestamp",
+		EntityCategory:    "diagnostic",
+	})
+	for _, z := range zones {
+		p.publishZoneDiscovery(z)
+	}
+}
+
+func (p *publisher) publishZoneDiscovery(z alarm.Zone) {
+	id := p.opts.ClientID
+	p.publishConfig("binary_sensor", ObjectID(z.Name), DiscoveryConfig{
+		Name:       z.Name,
+		UniqueID:   id + "_zone_" + ObjectID(z.Name),
+		Device:     newDevice(id),
+		StateTopic: p.topic(ObjectID(z.Name), "contact"),
+		// Zones are unavailable when the alarm is, which is set by its Last Will.
+		AvailabilityTopic: p.topic("alarm", "availability"),
+		DeviceClass:       zoneDeviceClasses[z.Type],
+		PayloadOn:         "payload_on",
+		PayloadOff:        "payload_off",
+	})
+}
+
+// publishConfig publishes a retained discovery config, so that Home Assistant
+// finds the entity when it restarts.
+func (p *publisher) publishConfig(component, object string, config DiscoveryConfig) {
+	payload, err := json.Marshal(config)
+	if err != nil {
+		p.opts.Logger.Error("Failed to marshal discovery config", logging.F("component", component), logging.F("object", object), logging.Err(err))
+		return
+	}
+	p.publish(fmt.Sprintf("%s/%s/%s/%s/config", p.opts.DiscoveryPrefix, component, p.opts.ClientID, object), 1, string(payload), true)
+}
+
+// publishStarted publishes the time that the alarm started, which Home
+// Assistant displays as the uptime.
+func (p *publisher) publishStarted(started time.Time) {
+	p.publishState(p.topic("alarm", "started"), started.Format(time.RFC3339))
+}
+
+func (p *publisher) publishFailures(failures int) {
+	p.publishState(p.topic("alarm", "failures"), fmt.Sprintf("%d", failures))
+}
+
+// ObjectID converts a zone name into a valid discovery object ID, which is also
+// used in the zone's topics. Zones must have different IDs to each other, and
+// to the alarm's ID, "alarm".
+func ObjectID(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -1,18 +1,21 @@
 package iot
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"io/ioutil"
+	"errors"
+	"strings"
+	"sync"
 	"time"
 
-	"log"
-
 	"github.com/a-h/alarm"
+	"github.com/a-h/alarm/logging"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
 type AlarmMessage struct {
+	// ID is an optional correlation ID, which is included in the result.
+	ID     string `json:"id,omitempty"`
 	Action string `json:"action"`
 	Code   string `json:"code"`
 }
@@ -22,142 +25,532 @@ type Credentials struct {
 	Password string `json:"pass"`
 	Broker   string `json:"broker"`
 	Port     int    `json:"port"`
+	// Scheme of the broker URL, tcp or mqtts. Defaults to mqtts if any of the
+	// TLS settings are set, otherwise tcp.
+	Scheme string `json:"scheme,omitempty"`
+	// CA is the path to a PEM file of the certificate authorities that the
+	// broker's certificate is checked against. Defaults to the system roots.
+	CA string `json:"ca,omitempty"`
+	// Cert and Key are the paths to the PEM encoded client certificate and key
+	// used to authenticate with the broker.
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+	// ServerName is checked against the broker's certificate. Defaults to Broker.
+	ServerName string `json:"serverName,omitempty"`
+	// SkipServerNameVerification checks that the broker's certificate is signed
+	// by the CA, but not that it's for the server name, e.g. when a self-signed
+	// certificate is used to connect to a broker by IP address.
+	SkipServerNameVerification bool `json:"skipServerNameVerification,omitempty"`
 }
 
-// New creates a new IoT alarm using MQTT.
-func New(controlAlarmFromIoT chan<- alarm.State, code *string) (updateStateFromDevice chan alarm.State, updateDoorIsOpenFromDevice chan bool, close func(), err error) {
-	// Listen for updates on the channels.
-	updateStateFromDevice = make(chan alarm.State, 10)
-	updateDoorIsOpenFromDevice = make(chan bool, 10)
+// Bridge connects the alarm to an MQTT broker. It's an alarm.Notifier, which
+// publishes changes in the order they're received, and an alarm.Controller,
+// which sends the commands received over MQTT to the alarm. The result of
+// each command is published to the result topic.
+type Bridge struct {
+	client   mqtt.Client
+	p        *publisher
+	url      string
+	commands chan alarm.Request
+	started  time.Time
+
+	// m protects the updates channel from being closed while a change is sent.
+	m       sync.Mutex
+	running bool
+	closed  bool
+	updates chan func()
+	// resync is set when a change is dropped because the updates channel is
+	// full, so that the latest state is published once it has been emptied.
+	resync bool
+	// alerts are published before the next change. Unlike changes, they're
+	// never dropped, since they can't be published again later.
+	alerts []func()
+	done   chan struct{}
+	once   sync.Once
 
-	var isOpen bool
-	var deviceStatus alarm.State
+	// stateM protects the latest state, which is published when the client
+	// connects. It's never held while publishing, since that can block.
+	stateM         sync.Mutex
+	status         alarm.Status
+	receivedStatus bool
+	zones          map[string]alarm.Zone
+	// discovered is the zones that discovery configs have been published for.
+	discovered map[string]bool
+}
 
-	// Read the credentials.
-	creds_data, err := ioutil.ReadFile("./creds.json")
+// New creates a Bridge using MQTT. Start must be called to connect to the
+// broker.
+func New(opts Options) (b *Bridge, err error) {
+	if opts.Logger == nil {
+		opts.Logger = logging.Nop{}
+	}
+	if err = opts.validate(); err != nil {
+		return nil, err
+	}
+	creds, err := readCredentials(opts.CredentialsPath)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := creds.tlsConfig()
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, err
+	}
+
+	b = &Bridge{
+		p:          &publisher{opts: opts},
+		url:        creds.brokerURL(),
+		commands:   make(chan alarm.Request, 10),
+		started:    time.Now(),
+		updates:    make(chan func(), 100),
+		done:       make(chan struct{}),
+		zones:      map[string]alarm.Zone{},
+		discovered: map[string]bool{},
 	}
-	var creds Credentials
-	err = json.Unmarshal(creds_data, &creds)
 
 	// Create the MQTT options.
 	options := mqtt.NewClientOptions()
-	options.AddBroker(fmt.Sprintf("tcp://%s:%d", creds.Broker, creds.Port))
-	options.SetClientID("go_mqtt_client")
+	options.AddBroker(b.url)
+	if tlsConfig != nil {
+		options.SetTLSConfig(tlsConfig)
+	}
+	options.SetClientID(opts.ClientID)
 	options.SetUsername(creds.Username)
 	options.SetPassword(creds.Password)
-	options.SetDefaultPublishHandler(func(client mqtt.Client, msg mqtt.Message) {
-		// Runs when a message that is subscribed to is received.
-		log.Printf("Received message: %s on topic: %s", msg.Payload(), msg.Topic())
-		var alarmMessage AlarmMessage
-		json.Unmarshal(msg.Payload(), &alarmMessage)
-		if alarmMessage.Code == *code {
-			switch alarmMessage.Action {
-			case "ARM_HOME":
-				controlAlarmFromIoT <- alarm.Armed
-			case "ARM_AWAY":
-				controlAlarmFromIoT <- alarm.Armed
-			case "DISARM":
-				controlAlarmFromIoT <- alarm.Disarmed
-			case "TRIGGER":
-				controlAlarmFromIoT <- alarm.Triggered
-			}
+	options.SetConnectTimeout(connectTimeout)
+	// Keep retrying the first connection, as if it were a reconnection, so
+	// that the alarm can start while the broker is down.
+	options.SetConnectRetry(true)
+	options.SetConnectRetryInterval(connectRetryInterval)
+	// Reconnect with a backoff that doubles up to the maximum interval.
+	options.SetAutoReconnect(true)
+	options.SetMaxReconnectInterval(maxReconnectInterval)
+	// Tell Home Assistant that the alarm is unavailable if the connection drops.
+	options.SetWill(b.p.topic("alarm", "availability"), "offline", opts.QoS, opts.Retain)
+	options.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		opts.Logger.Warn("Lost the MQTT connection, reconnecting", logging.Err(err))
+	})
+	options.SetOnConnectHandler(b.onConnect)
+	options.SetDefaultPublishHandler(b.onMessage)
+
+	b.client = mqtt.NewClient(options)
+	b.p.client = b.client
+	return b, nil
+}
+
+// Commands returns the commands received over MQTT.
+func (b *Bridge) Commands() <-chan alarm.Request {
+	return b.commands
+}
+
+// Start connects to the broker in the background, and publishes changes until
+// the context is cancelled or the bridge is closed. Changes made before the
+// connection is up are published once it connects.
+func (b *Bridge) Start(ctx context.Context) error {
+	b.m.Lock()
+	if b.closed || b.running {
+		b.m.Unlock()
+		return errors.New("iot: bridge has already been started or closed")
+	}
+	b.running = true
+	b.m.Unlock()
+
+	token := b.client.Connect()
+	go func() {
+		<-token.Done()
+		b.m.Lock()
+		closed := b.closed
+		b.m.Unlock()
+		// The connection is cancelled when the bridge is closed.
+		if err := token.Error(); err != nil && !closed {
+			b.p.opts.Logger.Warn("Failed to connect to MQTT", logging.F("url", b.url), logging.Err(err))
+		}
+	}()
+	go b.run()
+	go func() {
+		select {
+		case <-ctx.Done():
+			b.Close()
+		case <-b.done:
+		}
+	}()
+	return nil
+}
+
+func (b *Bridge) run() {
+	defer close(b.done)
+	for publish := range b.updates {
+		b.publishAlerts()
+		publish()
+		b.m.Lock()
+		resync := b.resync && len(b.updates) == 0
+		if resync {
+			b.resync = false
+		}
+		b.m.Unlock()
+		if resync {
+			b.publishState()
 		}
+	}
+	b.publishAlerts()
+}
+
+func (b *Bridge) publishAlerts() {
+	b.m.Lock()
+	alerts := b.alerts
+	b.alerts = nil
+	b.m.Unlock()
+	for _, publish := range alerts {
+		publish()
+	}
+}
+
+// Close stops publishing once the changes that have already been received are
+// published, marks the alarm as offline, and disconnects from the broker.
+func (b *Bridge) Close() {
+	b.once.Do(func() {
+		b.m.Lock()
+		b.closed = true
+		close(b.updates)
+		running := b.running
+		b.m.Unlock()
+		if !running {
+			return
+		}
+		<-b.done
+		// The Last Will is only sent if the connection drops, so the alarm must
+		// be marked as offline when it disconnects cleanly.
+		b.stateM.Lock()
+		zones := b.copyZones()
+		b.stateM.Unlock()
+		b.p.publishUnavailable(zones)
+		b.client.Disconnect(250)
 	})
+}
 
-	// Create the MQTT client.
-	client := mqtt.NewClient(options)
+// update sends a change to be published. Changes sent after the bridge is
+// closed are dropped. If publishing falls behind, e.g. while the broker is slow
+// to respond, changes are dropped rather than blocking the alarm, and the
+// latest state is published once publishing catches up.
+func (b *Bridge) update(publish func()) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.closed {
+		return
+	}
+	select {
+	case b.updates <- publish:
+	default:
+		b.resync = true
+		b.p.opts.Logger.Warn("Dropped an MQTT update, since the queue is full")
+	}
+}
 
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		panic(token.Error())
+// alert sends an alert to be published before the next change. Alerts are
+// never dropped, unless the bridge is closed.
+func (b *Bridge) alert(publish func()) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.closed {
+		return
 	}
+	b.alerts = append(b.alerts, publish)
+	// Wake the publishing goroutine. If the updates channel is full, it's
+	// already awake.
+	select {
+	case b.updates <- func() {}:
+	default:
+	}
+}
 
-	// Subscribe to the alarm topic.
-	subscribe(client, "home-assistant/alarm/control", 1)
+// copyZones returns a copy of the zones, so that they can be published without
+// holding stateM. stateM must be held.
+func (b *Bridge) copyZones() map[string]alarm.Zone {
+	zones := make(map[string]alarm.Zone, len(b.zones))
+	for name, z := range b.zones {
+		zones[name] = z
+	}
+	return zones
+}
 
-	// Publish the availability topic.
-	publishAvailable(client)
+// Notify publishes the changes made by the event.
+func (b *Bridge) Notify(e alarm.Event, s alarm.Status) {
+	switch e := e.(type) {
+	case alarm.Transition, alarm.CodeRejected:
+		b.PublishStatus(s)
+	case alarm.ZoneChanged:
+		b.PublishZone(e.Zone)
+	case alarm.Lockout:
+		b.PublishLockout(e)
+	case alarm.Duress:
+		b.PublishDuress(e)
+	}
+}
 
-	// Every 10 minutes, publish the current state.
-	ticker := time.NewTicker(10 * time.Minute)
-	quit := make(chan struct{})
+// PublishStatus publishes the state of the alarm and the number of failed
+// code entries.
+func (b *Bridge) PublishStatus(status alarm.Status) {
+	b.stateM.Lock()
+	b.status, b.receivedStatus = status, true
+	b.stateM.Unlock()
+	b.update(func() {
+		b.stateM.Lock()
+		status, zones := b.status, b.copyZones()
+		b.stateM.Unlock()
+		b.p.publishAvailable(zones)
+		b.p.publishAlarm(status)
+		b.p.publishFailures(status.Failures)
+	})
+}
 
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				log.Printf("Ticker: Restarting the MQTT connection.")
-				client.Disconnect(250) // allow 250ms for the disconnect to complete
-				if token := client.Connect(); token.Wait() && token.Error() != nil {
-					log.Printf("Ticker: Error restarting MQTT: %s", token.Error())
-				}
-				log.Printf("Ticker: Publishing current state")
-				publishAvailable(client)
-				publishAlarm(client, deviceStatus)
-				publishDoor(client, isOpen)
-				log.Printf("Ticker: Re-subscribing to topics")
-				subscribe(client, "home-assistant/alarm/control", 1)
-				log.Printf("Ticker: Done")
-			case <-quit:
-				ticker.Stop()
-				return
-			}
+// PublishZone publishes whether the zone is open. Home Assistant discovers
+// the zone when it's first published.
+func (b *Bridge) PublishZone(z alarm.Zone) {
+	b.stateM.Lock()
+	b.zones[z.Name] = z
+	b.stateM.Unlock()
+	b.update(func() {
+		b.stateM.Lock()
+		z, zones := b.zones[z.Name], b.copyZones()
+		discover := !b.discovered[z.Name]
+		b.discovered[z.Name] = true
+		b.stateM.Unlock()
+		if discover {
+			b.p.publishZoneDiscovery(z)
 		}
-	}()
+		b.p.publishZone(z)
+		b.p.publishAvailable(zones)
+	})
+}
+
+// PublishLockout publishes a lockout after too many incorrect codes.
+func (b *Bridge) PublishLockout(l alarm.Lockout) {
+	b.alert(func() {
+		b.p.publishLockout(l)
+	})
+}
+
+// PublishDuress publishes a silent alert when a duress code is used.
+func (b *Bridge) PublishDuress(d alarm.Duress) {
+	b.alert(func() {
+		b.p.publishDuress(d)
+	})
+}
+
+// onConnect runs on the first connection and after each reconnection, since
+// the subscription and retained messages may have been lost.
+func (b *Bridge) onConnect(client mqtt.Client) {
+	b.p.opts.Logger.Info("Connected to MQTT, publishing current state")
+	b.p.subscribe(b.p.topic("alarm", "control"))
+	b.publishState()
+	b.p.flush()
+}
+
+// publishState publishes the discovery configs and the latest state of the
+// alarm and its zones.
+func (b *Bridge) publishState() {
+	b.stateM.Lock()
+	status, receivedStatus, zones := b.status, b.receivedStatus, b.copyZones()
+	for name := range zones {
+		b.discovered[name] = true
+	}
+	b.stateM.Unlock()
+	b.p.publishDiscovery(zones)
+	b.p.publishStarted(b.started)
+	b.p.publishAvailable(zones)
+	if receivedStatus {
+		b.p.publishAlarm(status)
+		b.p.publishFailures(status.Failures)
+	}
+	for _, z := range zones {
+		b.p.publishZone(z)
+	}
+}
+
+// onMessage runs when a message that is subscribed to is received.
+func (b *Bridge) onMessage(client mqtt.Client, msg mqtt.Message) {
+	// The payload isn't logged, since it contains the code.
+	alarmMessage, command, err := parseCommand(msg.Payload())
+	if err != nil {
+		b.p.opts.Logger.Warn("Received malformed command", logging.F("topic", msg.Topic()), logging.Err(err))
+		b.p.publishResult(CommandResult{ID: alarmMessage.ID, Action: alarmMessage.Action, Result: ResultMalformed, Error: err.Error(), Time: time.Now()})
+		return
+	}
+	b.p.opts.Logger.Info("Received command", logging.F("topic", msg.Topic()), logging.F("action", alarmMessage.Action), logging.F("id", alarmMessage.ID))
+	result := make(chan error, 1)
+	b.commands <- alarm.Request{Command: command, Result: result}
+	// Wait for the result without blocking the client from receiving messages.
 	go func() {
-		for {
-			select {
-			case deviceStatus = <-updateStateFromDevice:
-				publishAvailable(client)
-				publishAlarm(client, deviceStatus)
-			case isOpen = <-updateDoorIsOpenFromDevice:
-				publishDoor(client, isOpen)
-				publishAvailable(client)
-			}
+		b.p.publishResult(newCommandResult(alarmMessage, <-result))
+	}()
+}
+
+const (
+	connectTimeout       = 30 * time.Second
+	connectRetryInterval = 10 * time.Second
+	maxReconnectInterval = 2 * time.Minute
+	publishTimeout       = 10 * time.Second
+	// maxQueued is the number of messages kept while the client is offline.
+	maxQueued = 100
+)
+
+// publisher publishes to the topics set in the options. Messages published
+// while the client is offline are queued until it reconnects.
+type publisher struct {
+	client mqtt.Client
+	opts   Options
+	m      sync.Mutex
+	queue  []message
+}
+
+type message struct {
+	topic   string
+	qos     byte
+	payload string
+	retain  bool
+}
+
+// topic returns the topic made from the topic prefix and the parts.
+func (p *publisher) topic(parts ...string) string {
+	return p.opts.TopicPrefix + "/" + strings.Join(parts, "/")
+}
+
+func (p *publisher) publish(topic string, qos byte, payload string, retain bool) {
+	msg := message{topic: topic, qos: qos, payload: payload, retain: retain}
+	if !p.client.IsConnectionOpen() {
+		p.enqueue(msg)
+		return
+	}
+	token := p.client.Publish(topic, qos, retain, payload)
+	if !token.WaitTimeout(publishTimeout) {
+		// The client keeps the message, and sends it once it reconnects.
+		p.opts.Logger.Warn("Timed out publishing", logging.F("topic", topic))
+		return
+	}
+	if err := token.Error(); err != nil {
+		p.opts.Logger.Warn("Failed to publish", logging.F("topic", topic), logging.Err(err))
+		p.enqueue(msg)
+	}
+}
 
+// enqueue a message to publish once the client reconnects. Only the latest
+// retained message for each topic is kept, since it replaces the others.
+func (p *publisher) enqueue(msg message) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if msg.retain {
+		for i, queued := range p.queue {
+			if queued.retain && queued.topic == msg.topic {
+				p.queue = append(p.queue[:i], p.queue[i+1:]...)
+				break
+			}
 		}
-	}()
-	return
+	}
+	if len(p.queue) >= maxQueued {
+		p.opts.Logger.Warn("MQTT queue is full, dropping message", logging.F("topic", p.queue[0].topic))
+		p.queue = p.queue[1:]
+	}
+	p.queue = append(p.queue, msg)
 }
 
-func publish(client mqtt.Client, topic string, qos byte, payload string, retain bool) {
-	token := client.Publish(topic, qos, retain, payload)
-	token.Wait()
+// flush publishes the queued messages.
+func (p *publisher) flush() {
+	p.m.Lock()
+	queue := p.queue
+	p.queue = nil
+	p.m.Unlock()
+	for _, msg := range queue {
+		p.publish(msg.topic, msg.qos, msg.payload, msg.retain)
+	}
+}
+
+// publishState publishes state using the QoS and retain flag set in the options.
+func (p *publisher) publishState(topic string, payload string) {
+	p.publish(topic, p.opts.QoS, payload, p.opts.Retain)
 }
 
-func subscribe(client mqtt.Client, topic string, qos byte) {
-	token := client.Subscribe(topic, qos, nil)
+func (p *publisher) subscribe(topic string) {
+	token := p.client.Subscribe(topic, p.opts.QoS, nil)
 	token.Wait()
-	log.Printf("Subscribed to topic %s", topic)
+	p.opts.Logger.Info("Subscribed", logging.F("topic", topic))
 }
 
-func publishDoor(client mqtt.Client, isOpen bool) {
-	log.Printf("Setting door value in MQTT: %v", isOpen)
-	if isOpen {
-		publish(client, "home-assistant/door/contact", 0, "payload_on", true)
+func (p *publisher) publishZone(z alarm.Zone) {
+	p.opts.Logger.Debug("Publishing zone", logging.F(logging.KeyZone, z.Name), logging.F("open", z.Open))
+	topic := p.topic(ObjectID(z.Name), "contact")
+	if z.Open {
+		p.publishState(topic, "payload_on")
 	} else {
-		publish(client, "home-assistant/door/contact", 0, "payload_off", true)
+		p.publishState(topic, "payload_off")
 	}
 }
 
-func publishAlarm(client mqtt.Client, deviceStatus alarm.State) {
-	log.Printf("Setting alarm value in MQTT: %v", deviceStatus)
-	switch deviceStatus {
+func (p *publisher) publishAlarm(deviceStatus alarm.Status) {
+	p.opts.Logger.Debug("Publishing alarm status", logging.F(logging.KeyState, alarm.StateNames[deviceStatus.State]), logging.F(logging.KeyMode, alarm.ModeNames[deviceStatus.Mode]))
+	topic := p.topic("alarm", "contact")
+	switch deviceStatus.State {
 	case alarm.Disarmed:
-		publish(client, "home-assistant/alarm/contact", 1, "disarmed", true)
+		p.publishState(topic, "disarmed")
 	case alarm.Armed:
-		publish(client, "home-assistant/alarm/contact", 1, "armed_away", true)
+		p.publishState(topic, armedStates[deviceStatus.Mode])
 	case alarm.Triggering:
-		publish(client, "home-assistant/alarm/contact", 1, "pending", true)
+		p.publishState(topic, "pending")
 	case alarm.Triggered:
-		publish(client, "home-assistant/alarm/contact", 1, "triggered", true)
+		p.publishState(topic, "triggered")
 	case alarm.Arming:
-		publish(client, "home-assistant/alarm/contact", 1, "arming", true)
+		p.publishState(topic, "arming")
+	}
+}
+
+// LockoutMessage is published when too many incorrect codes have been entered.
+type LockoutMessage struct {
+	Failures int       `json:"failures"`
+	Until    time.Time `json:"until"`
+}
+
+func (p *publisher) publishLockout(l alarm.Lockout) {
+	p.opts.Logger.Info("Publishing lockout", logging.F("until", l.Until))
+	payload, err := json.Marshal(LockoutMessage{Failures: l.Failures, Until: l.Until})
+	if err != nil {
+		p.opts.Logger.Error("Failed to marshal lockout", logging.Err(err))
+		return
 	}
+	p.publish(p.topic("alarm", "lockout"), p.opts.QoS, string(payload), false)
 }
 
-func publishAvailable(client mqtt.Client) {
-	publish(client, "home-assistant/alarm/availability", 1, "online", true)
-	publish(client, "home-assistant/door/availability", 1, "online", true)
+// DuressMessage is published when a duress code is used.
+type DuressMessage struct {
+	User  string    `json:"user"`
+	State string    `json:"state"`
+	Time  time.Time `json:"time"`
+}
+
+// publishDuress always uses QoS 2, so that the alert is delivered exactly once.
+func (p *publisher) publishDuress(d alarm.Duress) {
+	payload, err := json.Marshal(DuressMessage{User: d.User, State: alarm.StateNames[d.State], Time: d.Time})
+	if err != nil {
+		p.opts.Logger.Error("Failed to marshal duress alert", logging.Err(err))
+		return
+	}
+	p.publish(p.topic("alarm", "duress"), 2, string(payload), false)
+}
+
+var armedStates = map[alarm.Mode]string{
+	alarm.Away:  "armed_away",
+	alarm.Home:  "armed_home",
+	alarm.Night: "armed_night",
+}
+
+func (p *publisher) publishAvailable(zones map[string]alarm.Zone) {
+	p.publishAvailability(zones, "online")
+}
+
+func (p *publisher) publishUnavailable(zones map[string]alarm.Zone) {
+	p.publishAvailability(zones, "offline")
+}
+
+func (p *publisher) publishAvailability(zones map[string]alarm.Zone, availability string) {
+	p.publishState(p.topic("alarm", "availability"), availability)
+	for name := range zones {
+		p.publishState(p.topic(ObjectID(name), "availability"), availability)
+	}
 }
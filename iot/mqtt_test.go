@@ -0,0 +1,307 @@
+package iot
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/a-h/alarm"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestPublisherQueuesMessagesWhileOffline(t *testing.T) {
+	client := &fakeClient{}
+	p := &publisher{client: client, opts: DefaultOptions()}
+
+	p.publishState("home-assistant/alarm/contact", "arming")
+	p.publish("home-assistant/alarm/duress", 2, "duress", false)
+	p.publishState("home-assistant/alarm/contact", "armed_away")
+	if len(client.published) != 0 {
+		t.Fatalf("expected nothing to be published while offline, got %v", client.published)
+	}
+
+	client.connected = true
+	p.flush()
+	expected := []message{
+		{topic: "home-assistant/alarm/duress", qos: 2, payload: "duress"},
+		{topic: "home-assistant/alarm/contact", qos: 1, payload: "armed_away", retain: true},
+	}
+	if !reflect.DeepEqual(client.published, expected) {
+		t.Errorf("expected %v, got %v", expected, client.published)
+	}
+	if len(p.queue) != 0 {
+		t.Errorf("expected the queue to be empty, got %v", p.queue)
+	}
+}
+
+func TestPublisherDropsTheOldestMessagesWhenTheQueueIsFull(t *testing.T) {
+	client := &fakeClient{}
+	p := &publisher{client: client, opts: DefaultOptions()}
+	for i := 0; i < maxQueued+1; i++ {
+		p.publish("home-assistant/alarm/lockout", 1, string(rune('a'+i%26)), false)
+	}
+	if len(p.queue) != maxQueued {
+		t.Fatalf("expected %d queued messages, got %d", maxQueued, len(p.queue))
+	}
+	if p.queue[0].payload != "b" {
+		t.Errorf("expected the first message to have been dropped, got %q", p.queue[0].payload)
+	}
+}
+
+func TestBridgeCloseDrainsPendingChanges(t *testing.T) {
+	b, client := newTestBridge(t)
+	if err := b.Start(context.Background()); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	b.PublishZone(alarm.Zone{Name: "door", Open: true})
+	b.PublishZone(alarm.Zone{Name: "back door", Open: false})
+	b.PublishStatus(alarm.Status{State: alarm.Armed, Mode: alarm.Away})
+	b.Close()
+	b.PublishStatus(alarm.Status{State: alarm.Disarmed})
+
+	published := map[string]string{}
+	for _, msg := range client.published {
+		published[msg.topic] = msg.payload
+	}
+	expected := map[string]string{
+		"home-assistant/door/contact":           "payload_on",
+		"home-assistant/alarm/contact":          "armed_away",
+		"home-assistant/alarm/availability":     "offline",
+		"home-assistant/door/availability":      "offline",
+		"home-assistant/back_door/contact":      "payload_off",
+		"home-assistant/back_door/availability": "offline",
+	}
+	for topic, payload := range expected {
+		if published[topic] != payload {
+			t.Errorf("%s: expected %q, got %q", topic, payload, published[topic])
+		}
+	}
+	if !client.disconnected {
+		t.Error("expected the client to be disconnected")
+	}
+}
+
+func TestBridgeClosesWhenTheContextIsCancelled(t *testing.T) {
+	b, client := newTestBridge(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := b.Start(ctx); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	cancel()
+	select {
+	case <-b.done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the bridge to stop")
+	}
+	b.Close()
+	if !client.disconnected {
+		t.Error("expected the client to be disconnected")
+	}
+}
+
+func TestBridgeDropsUpdatesWhenTheQueueIsFull(t *testing.T) {
+	b, client := newTestBridge(t)
+	// The bridge isn't started, so nothing is taken from the queue.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < cap(b.updates)+1; i++ {
+			b.PublishStatus(alarm.Status{State: alarm.Armed, Failures: i})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the update to be dropped instead of blocking")
+	}
+	if len(b.updates) != cap(b.updates) {
+		t.Errorf("expected a full queue, got %d updates", len(b.updates))
+	}
+
+	// The latest status is published once the queue has been emptied.
+	if err := b.Start(context.Background()); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	b.Close()
+	var failures string
+	for _, msg := range client.published {
+		if msg.topic == "home-assistant/alarm/failures" {
+			failures = msg.payload
+		}
+	}
+	if expected := fmt.Sprint(cap(b.updates)); failures != expected {
+		t.Errorf("expected the latest failures, %s, to be published, got %q", expected, failures)
+	}
+}
+
+func TestBridgeNotifyDoesNotWaitForTheBroker(t *testing.T) {
+	b, client := newTestBridge(t)
+	client.release = make(chan struct{})
+	client.waiting = make(chan struct{}, 1)
+	if err := b.Start(context.Background()); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+
+	// The first change is stuck publishing, so the others must not wait for it.
+	b.Notify(alarm.ZoneChanged{Zone: alarm.Zone{Name: "door", Open: true}}, alarm.Status{})
+	<-client.waiting
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			b.Notify(alarm.Transition{To: alarm.Armed}, alarm.Status{State: alarm.Armed, Failures: i})
+			b.Notify(alarm.ZoneChanged{Zone: alarm.Zone{Name: "door", Open: i%2 == 0}}, alarm.Status{})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("expected Notify to return while the broker isn't responding")
+	}
+	close(client.release)
+	<-done
+	b.Close()
+}
+
+func TestBridgePublishesAlertsWhenTheQueueIsFull(t *testing.T) {
+	b, client := newTestBridge(t)
+	// The bridge isn't started, so nothing is taken from the queue.
+	for i := 0; i < cap(b.updates); i++ {
+		b.PublishStatus(alarm.Status{State: alarm.Armed})
+	}
+	b.PublishDuress(alarm.Duress{User: "alice", State: alarm.Disarmed})
+	if err := b.Start(context.Background()); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	b.Close()
+	var duress int
+	for _, msg := range client.published {
+		if msg.topic == "home-assistant/alarm/duress" {
+			duress++
+		}
+	}
+	if duress != 1 {
+		t.Errorf("expected the duress alert to be published once, got %d", duress)
+	}
+}
+
+func TestBridgeStartsWhileTheBrokerIsDown(t *testing.T) {
+	// Find a port that nothing is listening on.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	os.Setenv("ALARM_MQTT_BROKER", "127.0.0.1")
+	os.Setenv("ALARM_MQTT_PORT", strconv.Itoa(port))
+	defer os.Unsetenv("ALARM_MQTT_BROKER")
+	defer os.Unsetenv("ALARM_MQTT_PORT")
+	opts := DefaultOptions()
+	opts.CredentialsPath = "missing.json"
+	opts.ClientID = "test"
+	b, err := New(opts)
+	if err != nil {
+		t.Fatalf("failed to create bridge: %v", err)
+	}
+	defer b.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := b.Start(ctx); err != nil {
+		t.Fatalf("expected the bridge to start without the broker, got %v", err)
+	}
+	b.PublishStatus(alarm.Status{State: alarm.Armed, Mode: alarm.Away})
+}
+
+func newTestBridge(t *testing.T) (*Bridge, *fakeClient) {
+	os.Setenv("ALARM_MQTT_BROKER", "localhost")
+	defer os.Unsetenv("ALARM_MQTT_BROKER")
+	opts := DefaultOptions()
+	opts.CredentialsPath = "missing.json"
+	opts.ClientID = "test"
+	b, err := New(opts)
+	if err != nil {
+		t.Fatalf("failed to create bridge: %v", err)
+	}
+	client := &fakeClient{connected: true}
+	b.client, b.p.client = client, client
+	return b, client
+}
+
+type fakeClient struct {
+	mqtt.Client
+	connected    bool
+	disconnected bool
+	published    []message
+	// release is closed to complete publishes. If it's nil, they complete
+	// immediately.
+	release chan struct{}
+	// waiting receives when a publish is waiting to be released.
+	waiting chan struct{}
+}
+
+func (c *fakeClient) Connect() mqtt.Token {
+	return completedToken{}
+}
+
+func (c *fakeClient) Disconnect(quiesce uint) {
+	c.disconnected = true
+}
+
+func (c *fakeClient) IsConnectionOpen() bool {
+	return c.connected
+}
+
+func (c *fakeClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.published = append(c.published, message{topic: topic, qos: qos, payload: payload.(string), retain: retained})
+	if c.release != nil {
+		return releasedToken{release: c.release, waiting: c.waiting}
+	}
+	return completedToken{}
+}
+
+// releasedToken completes when release is closed.
+type releasedToken struct {
+	completedToken
+	release chan struct{}
+	waiting chan struct{}
+}
+
+func (t releasedToken) Wait() bool {
+	<-t.release
+	return true
+}
+
+func (t releasedToken) WaitTimeout(d time.Duration) bool {
+	select {
+	case t.waiting <- struct{}{}:
+	default:
+	}
+	select {
+	case <-t.release:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func (t releasedToken) Done() <-chan struct{} {
+	return t.release
+}
+
+type completedToken struct{}
+
+func (completedToken) Wait() bool                     { return true }
+func (completedToken) WaitTimeout(time.Duration) bool { return true }
+func (completedToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+func (completedToken) Error() error { return nil }
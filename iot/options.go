@@ -0,0 +1,124 @@
+package iot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/a-h/alarm/logging"
+)
+
+// Options configure the MQTT connection.
+type Options struct {
+	// CredentialsPath is the path to the JSON credentials file.
+	CredentialsPath string
+	// ClientID must be unique for each alarm connected to the broker. It's also
+	// used to identify the alarm in Home Assistant.
+	ClientID string
+	// TopicPrefix is the start of each state and command topic, e.g.
+	// home-assistant/alarm/contact.
+	TopicPrefix string
+	// DiscoveryPrefix is the topic prefix that Home Assistant watches for MQTT
+	// discovery messages.
+	DiscoveryPrefix string
+	// QoS of the published state and the command subscription.
+	QoS byte
+	// Retain the state, zone and availability messages, so that the broker
+	// sends them to new subscribers.
+	Retain bool
+	// Logger receives the connection and publishing messages.
+	Logger logging.Logger
+}
+
+// DefaultOptions returns the options used by earlier versions of the alarm,
+// with a client ID that's unique to the machine.
+func DefaultOptions() Options {
+	return Options{
+		CredentialsPath: "creds.json",
+		ClientID:        deviceID(),
+		TopicPrefix:     "home-assistant",
+		DiscoveryPrefix: "homeassistant",
+		QoS:             1,
+		Retain:          true,
+		Logger:          logging.Nop{},
+	}
+}
+
+// ApplyEnv overrides the options with any of the ALARM_MQTT_CREDS,
+// ALARM_MQTT_CLIENT_ID, ALARM_MQTT_TOPIC_PREFIX, ALARM_MQTT_DISCOVERY_PREFIX,
+// ALARM_MQTT_QOS and ALARM_MQTT_RETAIN environment variables that are set.
+func (o *Options) ApplyEnv() error {
+	if v, ok := os.LookupEnv("ALARM_MQTT_CREDS"); ok {
+		o.CredentialsPath = v
+	}
+	if v, ok := os.LookupEnv("ALARM_MQTT_CLIENT_ID"); ok {
+		o.ClientID = v
+	}
+	if v, ok := os.LookupEnv("ALARM_MQTT_TOPIC_PREFIX"); ok {
+		o.TopicPrefix = v
+	}
+	if v, ok := os.LookupEnv("ALARM_MQTT_DISCOVERY_PREFIX"); ok {
+		o.DiscoveryPrefix = v
+	}
+	if v, ok := os.LookupEnv("ALARM_MQTT_QOS"); ok {
+		qos, err := strconv.ParseUint(v, 10, 8)
+		if err != nil {
+			return fmt.Errorf("iot: invalid ALARM_MQTT_QOS: %w", err)
+		}
+		o.QoS = byte(qos)
+	}
+	if v, ok := os.LookupEnv("ALARM_MQTT_RETAIN"); ok {
+		retain, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("iot: invalid ALARM_MQTT_RETAIN: %w", err)
+		}
+		o.Retain = retain
+	}
+	return nil
+}
+
+func (o Options) validate() error {
+	if o.ClientID == "" {
+		return fmt.Errorf("iot: client ID is required")
+	}
+	if o.TopicPrefix == "" || o.DiscoveryPrefix == "" {
+		return fmt.Errorf("iot: topic prefixes are required")
+	}
+	if o.QoS > 2 {
+		return fmt.Errorf("iot: invalid QoS %d", o.QoS)
+	}
+	return nil
+}
+
+// readCredentials reads the credentials file, then overrides it with any of
+// the ALARM_MQTT_BROKER, ALARM_MQTT_PORT, ALARM_MQTT_USER and ALARM_MQTT_PASS
+// environment variables that are set. The file can be left out if the broker
+// is set in the environment.
+func readCredentials(path string) (creds Credentials, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !(os.IsNotExist(err) && os.Getenv("ALARM_MQTT_BROKER") != "") {
+		return creds, err
+	}
+	if err == nil {
+		if err = json.Unmarshal(data, &creds); err != nil {
+			return creds, fmt.Errorf("iot: failed to read credentials from %q: %w", path, err)
+		}
+	}
+	if v, ok := os.LookupEnv("ALARM_MQTT_BROKER"); ok {
+		creds.Broker = v
+	}
+	if v, ok := os.LookupEnv("ALARM_MQTT_PORT"); ok {
+		if creds.Port, err = strconv.Atoi(v); err != nil {
+			return creds, fmt.Errorf("iot: invalid ALARM_MQTT_PORT: %w", err)
+		}
+	}
+	if v, ok := os.LookupEnv("ALARM_MQTT_USER"); ok {
+		creds.Username = v
+	}
+	if v, ok := os.LookupEnv("ALARM_MQTT_PASS"); ok {
+		creds.Password = v
+	}
+	return creds, nil
+}
@@ -0,0 +1,77 @@
+package iot
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// useTLS returns true if the connection to the broker should use TLS.
+func (c Credentials) useTLS() bool {
+	if c.Scheme != "" {
+		return c.Scheme != "tcp"
+	}
+	return c.CA != "" || c.Cert != "" || c.ServerName != "" || c.SkipServerNameVerification
+}
+
+// brokerURL returns the URL of the broker.
+func (c Credentials) brokerURL() string {
+	scheme := c.Scheme
+	if scheme == "" {
+		scheme = "tcp"
+		if c.useTLS() {
+			scheme = "mqtts"
+		}
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, c.Broker, c.Port)
+}
+
+// tlsConfig returns the TLS configuration used to connect to the broker, or
+// nil if TLS isn't used.
+func (c Credentials) tlsConfig() (*tls.Config, error) {
+	if !c.useTLS() {
+		return nil, nil
+	}
+	config := &tls.Config{
+		ServerName: c.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if c.CA != "" {
+		pem, err := ioutil.ReadFile(c.CA)
+		if err != nil {
+			return nil, fmt.Errorf("iot: failed to read CA: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("iot: no certificates found in CA %q", c.CA)
+		}
+	}
+	if c.Cert != "" || c.Key != "" {
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, fmt.Errorf("iot: failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if c.SkipServerNameVerification {
+		// Verify the certificate chain without checking the server name.
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("iot: broker did not send a certificate")
+			}
+			opts := x509.VerifyOptions{
+				Roots:         config.RootCAs,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,205 @@
+package iot
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBrokerURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		creds    Credentials
+		expected string
+	}{
+		{
+			name:     "plain",
+			creds:    Credentials{Broker: "localhost", Port: 1883},
+			expected: "tcp://localhost:1883",
+		},
+		{
+			name:     "TLS settings imply mqtts",
+			creds:    Credentials{Broker: "localhost", Port: 8883, CA: "ca.pem"},
+			expected: "mqtts://localhost:8883",
+		},
+		{
+			name:     "scheme",
+			creds:    Credentials{Broker: "localhost", Port: 8883, Scheme: "mqtts"},
+			expected: "mqtts://localhost:8883",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.creds.brokerURL(); actual != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alarm-tls")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := newCertificate(t, "ca", nil, nil)
+	server, serverKey := newCertificate(t, "broker.local", ca, caKey)
+	client, clientKey := newCertificate(t, "alarm", ca, caKey)
+	caPath := writePEM(t, dir, "ca.pem", ca, nil)
+	clientPath := writePEM(t, dir, "client.pem", client, nil)
+	clientKeyPath := writePEM(t, dir, "client-key.pem", nil, clientKey)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{server.Raw}, PrivateKey: serverKey}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    roots,
+	})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	tests := []struct {
+		name        string
+		creds       Credentials
+		expectError bool
+	}{
+		{
+			name:  "server name",
+			creds: Credentials{CA: caPath, Cert: clientPath, Key: clientKeyPath, ServerName: "broker.local"},
+		},
+		{
+			name:        "wrong server name",
+			creds:       Credentials{CA: caPath, Cert: clientPath, Key: clientKeyPath},
+			expectError: true,
+		},
+		{
+			name:  "skip server name verification",
+			creds: Credentials{CA: caPath, Cert: clientPath, Key: clientKeyPath, SkipServerNameVerification: true},
+		},
+		{
+			name:        "skip server name verification still checks the CA",
+			creds:       Credentials{Cert: clientPath, Key: clientKeyPath, SkipServerNameVerification: true},
+			expectError: true,
+		},
+		{
+			name:        "no client certificate",
+			creds:       Credentials{CA: caPath, ServerName: "broker.local"},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := test.creds.tlsConfig()
+			if err != nil {
+				t.Fatalf("unexpected error creating config: %v", err)
+			}
+			if config.ServerName == "" {
+				config.ServerName = "127.0.0.1"
+			}
+			conn, err := tls.Dial("tcp", l.Addr().String(), config)
+			if err == nil {
+				// The server's rejection of the client certificate is only seen
+				// on the first read.
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				_, err = conn.Read(make([]byte, 1))
+				if err == io.EOF {
+					err = nil
+				}
+				conn.Close()
+			}
+			if test.expectError && err == nil {
+				t.Error("expected an error, got nil")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestTLSConfigIsNilWithoutTLS(t *testing.T) {
+	config, err := Credentials{Broker: "localhost", Port: 1883}.tlsConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config != nil {
+		t.Errorf("expected no TLS config, got %v", config)
+	}
+}
+
+// newCertificate creates a certificate signed by the parent, or a self-signed
+// CA if the parent is nil.
+func newCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{name},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return cert, key
+}
+
+func writePEM(t *testing.T, dir, name string, cert *x509.Certificate, key *ecdsa.PrivateKey) string {
+	block := &pem.Block{}
+	if cert != nil {
+		block.Type, block.Bytes = "CERTIFICATE", cert.Raw
+	} else {
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatalf("failed to marshal key: %v", err)
+		}
+		block.Type, block.Bytes = "EC PRIVATE KEY", der
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+	return path
+}
@@ -0,0 +1,311 @@
+// Package journal records the alarm's events in an append-only log on disk,
+// which can be queried by time and type.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/a-h/alarm"
+	"github.com/a-h/alarm/logging"
+)
+
+// Type of an entry.
+type Type string
+
+// Types of entry.
+const (
+	TypePowerUp        Type = "power_up"
+	TypeArming         Type = "arming"
+	TypeArmed          Type = "armed"
+	TypeDisarmed       Type = "disarmed"
+	TypeTriggering     Type = "triggering"
+	TypeTriggered      Type = "triggered"
+	TypeSirenStopped   Type = "siren_stopped"
+	TypeCodeRejected   Type = "code_rejected"
+	TypeLockout        Type = "lockout"
+	TypeUserAdded      Type = "user_added"
+	TypeUserRemoved    Type = "user_removed"
+	TypeCodeChanged    Type = "code_changed"
+	TypeZoneOpened     Type = "zone_opened"
+	TypeZoneClosed     Type = "zone_closed"
+	TypeZoneBypassed   Type = "zone_bypassed"
+	TypeZoneUnbypassed Type = "zone_unbypassed"
+	TypeRemoteCommand  Type = "remote_command"
+)
+
+var stateTypes = map[alarm.State]Type{
+	alarm.Arming:     TypeArming,
+	alarm.Armed:      TypeArmed,
+	alarm.Disarmed:   TypeDisarmed,
+	alarm.Triggering: TypeTriggering,
+	alarm.Triggered:  TypeTriggered,
+}
+
+// Entry in the journal.
+type Entry struct {
+	Time time.Time `json:"time"`
+	Type Type      `json:"type"`
+	// User that caused the entry, if known.
+	User  string `json:"user,omitempty"`
+	Cause string `json:"cause,omitempty"`
+	Mode  string `json:"mode,omitempty"`
+	Zone  string `json:"zone,omitempty"`
+	// Message has any other details.
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+const (
+	defaultMaxSize  = 1024 * 1024
+	defaultMaxFiles = 5
+)
+
+// Journal is an append-only log of entries, stored as a line of JSON each.
+// When the file reaches MaxSize, it's renamed with a .1 suffix, and older
+// files are renamed with the next suffix, up to MaxFiles.
+type Journal struct {
+	Path     string
+	MaxSize  int64
+	MaxFiles int
+	// Logger receives the errors writing the alarm's events, which Notify
+	// can't return. Defaults to no logging.
+	Logger logging.Logger
+
+	m    sync.Mutex
+	f    *os.File
+	size int64
+	// zones is the last state of each zone, used to tell whether a zone was
+	// opened or bypassed.
+	zones map[string]alarm.Zone
+}
+
+// Open the journal at the path, creating it if it doesn't exist.
+func Open(path string) (*Journal, error) {
+	j := &Journal{
+		Path:     path,
+		MaxSize:  defaultMaxSize,
+		MaxFiles: defaultMaxFiles,
+		Logger:   logging.Nop{},
+		zones:    map[string]alarm.Zone{},
+	}
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *Journal) open() (err error) {
+	j.f, err = os.OpenFile(j.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := j.f.Stat()
+	if err != nil {
+		j.f.Close()
+		return err
+	}
+	j.size = fi.Size()
+	return nil
+}
+
+// Close the journal.
+func (j *Journal) Close() error {
+	j.m.Lock()
+	defer j.m.Unlock()
+	return j.f.Close()
+}
+
+// Write an entry to the journal. If the entry has no time, the current time
+// is used.
+func (j *Journal) Write(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	j.m.Lock()
+	defer j.m.Unlock()
+	if j.size > 0 && j.size+int64(len(data)) > j.MaxSize {
+		if err = j.rotate(); err != nil {
+			return fmt.Errorf("journal: failed to rotate: %w", err)
+		}
+	}
+	n, err := j.f.Write(data)
+	j.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+func (j *Journal) rotate() error {
+	if err := j.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(j.rotated(j.MaxFiles)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := j.MaxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(j.rotated(i), j.rotated(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(j.Path, j.rotated(1)); err != nil {
+		return err
+	}
+	return j.open()
+}
+
+// rotated returns the path of the nth rotated file. Higher numbers are older.
+func (j *Journal) rotated(n int) string {
+	return fmt.Sprintf("%s.%d", j.Path, n)
+}
+
+// Query selects entries from the journal.
+type Query struct {
+	// From and To restrict the entries to a time range. From is inclusive, To
+	// is exclusive. Zero values are unbounded.
+	From time.Time
+	To   time.Time
+	// Types of entry to return. Empty returns every type.
+	Types []Type
+	// Limit the number of entries to the most recent. Zero is unlimited.
+	Limit int
+}
+
+func (q Query) matches(e Entry) bool {
+	if !q.From.IsZero() && e.Time.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !e.Time.Before(q.To) {
+		return false
+	}
+	if len(q.Types) == 0 {
+		return true
+	}
+	for _, t := range q.Types {
+		if t == e.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// Query returns the entries that match the query, oldest first.
+func (j *Journal) Query(q Query) (entries []Entry, err error) {
+	j.m.Lock()
+	defer j.m.Unlock()
+	for i := j.MaxFiles; i >= 0; i-- {
+		path := j.Path
+		if i > 0 {
+			path = j.rotated(i)
+		}
+		if entries, err = query(path, q, entries); err != nil {
+			return nil, err
+		}
+	}
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[len(entries)-q.Limit:]
+	}
+	return entries, nil
+}
+
+func query(path string, q Query, entries []Entry) ([]Entry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// Skip lines that were only partly written before a power cut.
+			continue
+		}
+		if q.matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// Notify writes an entry for the event. Duress events are left out, since
+// they must stay silent, and so are display changes, which show the keys
+// pressed.
+func (j *Journal) Notify(e alarm.Event, _ alarm.Status) {
+	entry, ok := j.entry(e)
+	if !ok {
+		return
+	}
+	if err := j.Write(entry); err != nil {
+		j.Logger.Error("Failed to write to the journal", logging.Err(err))
+	}
+}
+
+func (j *Journal) entry(e alarm.Event) (entry Entry, ok bool) {
+	switch e := e.(type) {
+	case alarm.Transition:
+		entry = Entry{Time: e.Time, Type: stateTypes[e.To], User: e.User, Cause: string(e.Cause)}
+		if e.To != alarm.Disarmed {
+			entry.Mode = alarm.ModeNames[e.Mode]
+		}
+	case alarm.SirenStopped:
+		entry = Entry{Time: e.Time, Type: TypeSirenStopped, Message: "alarm memory shown"}
+		if e.Rearmed {
+			entry.Message = "re-armed"
+		}
+	case alarm.CodeRejected:
+		entry = Entry{Time: e.Time, Type: TypeCodeRejected, Cause: string(e.Cause), Message: fmt.Sprintf("%d consecutive failures", e.Failures)}
+	case alarm.Lockout:
+		entry = Entry{Time: e.Time, Type: TypeLockout, Message: fmt.Sprintf("locked out until %v after %d failures", e.Until.Format(time.RFC3339), e.Failures)}
+	case alarm.UserChanged:
+		entry = Entry{Time: e.Time, Type: TypeCodeChanged, User: e.By, Message: e.Name}
+		if e.Added {
+			entry.Type = TypeUserAdded
+		}
+		if e.Removed {
+			entry.Type = TypeUserRemoved
+		}
+	case alarm.ZoneChanged:
+		j.m.Lock()
+		previous, known := j.zones[e.Zone.Name]
+		j.zones[e.Zone.Name] = e.Zone
+		j.m.Unlock()
+		entry = Entry{Time: e.Time, Zone: e.Zone.Name}
+		// Zones are most often opened and closed, so that's assumed if the
+		// previous state of the zone isn't known.
+		switch {
+		case (!known || e.Zone.Open != previous.Open) && e.Zone.Open:
+			entry.Type = TypeZoneOpened
+		case !known || e.Zone.Open != previous.Open:
+			entry.Type = TypeZoneClosed
+		case e.Zone.Bypassed:
+			entry.Type = TypeZoneBypassed
+		default:
+			entry.Type = TypeZoneUnbypassed
+		}
+	case alarm.RemoteCommand:
+		entry = Entry{Time: e.Time, Type: TypeRemoteCommand, Message: alarm.StateNames[e.State]}
+		if e.State == alarm.Arming || e.State == alarm.Armed {
+			entry.Mode = alarm.ModeNames[e.Mode]
+		}
+		if e.Err != nil {
+			entry.Error = e.Err.Error()
+		}
+	default:
+		return entry, false
+	}
+	return entry, true
+}
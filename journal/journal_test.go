@@ -0,0 +1,133 @@
+package journal
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/a-h/alarm"
+)
+
+func newTestJournal(t *testing.T) (j *Journal, cleanup func()) {
+	dir, err := ioutil.TempDir("", "alarm-journal")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	j, err = Open(filepath.Join(dir, "journal.jsonl"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("failed to open journal: %v", err)
+	}
+	return j, func() {
+		j.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	j, cleanup := newTestJournal(t)
+	defer cleanup()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := []Entry{
+		{Time: start, Type: TypePowerUp},
+		{Time: start.Add(time.Hour), Type: TypeArming, User: "admin"},
+		{Time: start.Add(2 * time.Hour), Type: TypeZoneOpened, Zone: "door"},
+		{Time: start.Add(3 * time.Hour), Type: TypeDisarmed, User: "admin"},
+	}
+	for _, e := range entries {
+		if err := j.Write(e); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		query    Query
+		expected []Entry
+	}{
+		{name: "all", query: Query{}, expected: entries},
+		{name: "time range", query: Query{From: start.Add(time.Hour), To: start.Add(3 * time.Hour)}, expected: entries[1:3]},
+		{name: "types", query: Query{Types: []Type{TypeArming, TypeDisarmed}}, expected: []Entry{entries[1], entries[3]}},
+		{name: "limit", query: Query{Limit: 2}, expected: entries[2:]},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := j.Query(test.query)
+			if err != nil {
+				t.Fatalf("failed to query: %v", err)
+			}
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expected %+v, got %+v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	j, cleanup := newTestJournal(t)
+	defer cleanup()
+	j.MaxSize = 200
+	j.MaxFiles = 2
+	for i := 0; i < 20; i++ {
+		if err := j.Write(Entry{Type: TypeZoneOpened, Zone: "door"}); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+	for _, path := range []string{j.Path, j.rotated(1), j.rotated(2)} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("expected %s to exist: %v", path, err)
+		}
+		if fi.Size() > j.MaxSize {
+			t.Errorf("expected %s to be rotated at %d bytes, got %d", path, j.MaxSize, fi.Size())
+		}
+	}
+	if _, err := os.Stat(j.rotated(3)); !os.IsNotExist(err) {
+		t.Errorf("expected only %d rotated files to be kept", j.MaxFiles)
+	}
+	entries, err := j.Query(Query{})
+	if err != nil {
+		t.Fatalf("failed to query: %v", err)
+	}
+	if len(entries) == 0 || len(entries) >= 20 {
+		t.Errorf("expected the oldest entries to have been removed, got %d entries", len(entries))
+	}
+}
+
+func TestNotify(t *testing.T) {
+	j, cleanup := newTestJournal(t)
+	defer cleanup()
+	now := time.Now().UTC().Truncate(time.Second)
+	events := []alarm.Event{
+		alarm.Transition{From: alarm.Disarmed, To: alarm.Arming, Mode: alarm.Night, Cause: alarm.CauseKeypad, User: "admin", Time: now},
+		alarm.DisplayChanged{Display: "1234", Time: now},
+		alarm.Duress{User: "duress", Time: now},
+		alarm.ZoneChanged{Zone: alarm.Zone{Name: "door", Open: true}, Time: now},
+		alarm.ZoneChanged{Zone: alarm.Zone{Name: "door", Open: true, Bypassed: true}, Time: now},
+		alarm.ZoneChanged{Zone: alarm.Zone{Name: "door", Bypassed: true}, Time: now},
+		alarm.UserChanged{Name: "user 2", Added: true, By: "admin", Time: now},
+		alarm.RemoteCommand{State: alarm.Disarmed, Err: errors.New("alarm: incorrect code"), Time: now},
+	}
+	for _, e := range events {
+		j.Notify(e, alarm.Status{})
+	}
+	actual, err := j.Query(Query{})
+	if err != nil {
+		t.Fatalf("failed to query: %v", err)
+	}
+	expected := []Entry{
+		{Time: now, Type: TypeArming, User: "admin", Cause: "keypad", Mode: "Night"},
+		{Time: now, Type: TypeZoneOpened, Zone: "door"},
+		{Time: now, Type: TypeZoneBypassed, Zone: "door"},
+		{Time: now, Type: TypeZoneClosed, Zone: "door"},
+		{Time: now, Type: TypeUserAdded, User: "admin", Message: "user 2"},
+		{Time: now, Type: TypeRemoteCommand, Message: "Disarmed", Error: "alarm: incorrect code"},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %+v, got %+v", expected, actual)
+	}
+}
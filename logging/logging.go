@@ -0,0 +1,229 @@
+// Package logging writes structured, leveled log messages, as text or as
+// JSON for shipping to journald.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level of a log message.
+type Level int
+
+const (
+	// LevelDebug messages help to diagnose problems.
+	LevelDebug Level = iota
+	// LevelInfo messages record what the alarm is doing.
+	LevelInfo
+	// LevelWarn messages record problems that the alarm recovers from.
+	LevelWarn
+	// LevelError messages record failures.
+	LevelError
+)
+
+// LevelNames contains the names of the levels.
+var LevelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+// ParseLevel returns the level with the name.
+func ParseLevel(name string) (Level, error) {
+	for l, n := range LevelNames {
+		if strings.EqualFold(n, name) {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("logging: unknown level %q", name)
+}
+
+// Keys of the fields used across the alarm.
+const (
+	KeyState  = "state"
+	KeyMode   = "mode"
+	KeyZone   = "zone"
+	KeyUser   = "user"
+	KeySource = "source"
+	KeyError  = "error"
+)
+
+// Field is a key and value added to a message.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F creates a field.
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Err creates an error field.
+func Err(err error) Field {
+	return Field{Key: KeyError, Value: err}
+}
+
+// redacted is written instead of the value of a field that holds a secret.
+const redacted = "[redacted]"
+
+// secretKeys are the keys of fields whose values are never written.
+var secretKeys = []string{"code", "pin", "pass", "password", "token", "secret", "payload"}
+
+func isSecret(key string) bool {
+	key = strings.ToLower(key)
+	for _, k := range secretKeys {
+		if key == k || strings.HasSuffix(key, "_"+k) || strings.HasSuffix(key, "."+k) {
+			return true
+		}
+	}
+	return false
+}
+
+// Logger writes structured log messages.
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+	// With returns a Logger that adds the fields to every message.
+	With(fields ...Field) Logger
+}
+
+// Options configure a Log.
+type Options struct {
+	// Level is the lowest level of message that's written.
+	Level Level
+	// JSON writes each message as a line of JSON, instead of text.
+	JSON bool
+}
+
+// Log writes messages to an io.Writer. Fields with secret keys, such as code
+// or token, are redacted.
+type Log struct {
+	opts   Options
+	fields []Field
+	// m is shared between a Log and the Logs created by With, so that lines
+	// are never interleaved.
+	m  *sync.Mutex
+	w  io.Writer
+	at func() time.Time
+}
+
+// New creates a Log that writes to w.
+func New(w io.Writer, opts Options) *Log {
+	return &Log{
+		opts: opts,
+		m:    &sync.Mutex{},
+		w:    w,
+		at:   time.Now,
+	}
+}
+
+// Debug writes a debug message.
+func (l *Log) Debug(msg string, fields ...Field) { l.log(LevelDebug, msg, fields) }
+
+// Info writes an info message.
+func (l *Log) Info(msg string, fields ...Field) { l.log(LevelInfo, msg, fields) }
+
+// Warn writes a warning message.
+func (l *Log) Warn(msg string, fields ...Field) { l.log(LevelWarn, msg, fields) }
+
+// Error writes an error message.
+func (l *Log) Error(msg string, fields ...Field) { l.log(LevelError, msg, fields) }
+
+// With returns a Log that adds the fields to every message.
+func (l *Log) With(fields ...Field) Logger {
+	c := *l
+	c.fields = append(append([]Field{}, l.fields...), fields...)
+	return &c
+}
+
+func (l *Log) log(level Level, msg string, fields []Field) {
+	if level < l.opts.Level {
+		return
+	}
+	all := append(append([]Field{}, l.fields...), fields...)
+	var line string
+	if l.opts.JSON {
+		line = l.json(level, msg, all)
+	} else {
+		line = l.text(level, msg, all)
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+	io.WriteString(l.w, line)
+}
+
+func value(f Field) interface{} {
+	if isSecret(f.Key) {
+		return redacted
+	}
+	switch v := f.Value.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return f.Value
+}
+
+func (l *Log) text(level Level, msg string, fields []Field) string {
+	var sb strings.Builder
+	sb.WriteString(l.at().Format(time.RFC3339))
+	sb.WriteString(" ")
+	sb.WriteString(strings.ToUpper(LevelNames[level]))
+	sb.WriteString(" ")
+	sb.WriteString(msg)
+	for _, f := range fields {
+		v := fmt.Sprint(value(f))
+		if strings.ContainsAny(v, " \"=") {
+			v = fmt.Sprintf("%q", v)
+		}
+		fmt.Fprintf(&sb, " %s=%s", f.Key, v)
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+func (l *Log) json(level Level, msg string, fields []Field) string {
+	m := map[string]interface{}{}
+	for _, f := range fields {
+		m[f.Key] = value(f)
+	}
+	m["time"] = l.at().Format(time.RFC3339Nano)
+	m["level"] = LevelNames[level]
+	m["msg"] = msg
+	data, err := json.Marshal(m)
+	if err != nil {
+		// Fall back to the field values as text, which can always be marshalled.
+		for k, v := range m {
+			m[k] = fmt.Sprint(v)
+		}
+		data, _ = json.Marshal(m)
+	}
+	return string(data) + "\n"
+}
+
+// Nop discards every message.
+type Nop struct{}
+
+// Debug discards the message.
+func (Nop) Debug(msg string, fields ...Field) {}
+
+// Info discards the message.
+func (Nop) Info(msg string, fields ...Field) {}
+
+// Warn discards the message.
+func (Nop) Warn(msg string, fields ...Field) {}
+
+// Error discards the message.
+func (Nop) Error(msg string, fields ...Field) {}
+
+// With returns the Nop logger.
+func (n Nop) With(fields ...Field) Logger { return n }
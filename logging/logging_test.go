@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestLog(opts Options) (*Log, *bytes.Buffer) {
+	var buf bytes.Buffer
+	l := New(&buf, opts)
+	l.at = func() time.Time { return time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC) }
+	return l, &buf
+}
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		name     string
+		log      func(l Logger)
+		expected string
+	}{
+		{
+			name:     "message",
+			log:      func(l Logger) { l.Info("Armed") },
+			expected: "2020-01-01T12:00:00Z INFO Armed\n",
+		},
+		{
+			name:     "fields",
+			log:      func(l Logger) { l.Warn("Zone opened", F(KeyZone, "front door"), F("open", true)) },
+			expected: "2020-01-01T12:00:00Z WARN Zone opened zone=\"front door\" open=true\n",
+		},
+		{
+			name:     "error",
+			log:      func(l Logger) { l.Error("Failed to save", Err(errors.New("disk full"))) },
+			expected: "2020-01-01T12:00:00Z ERROR Failed to save error=\"disk full\"\n",
+		},
+		{
+			name:     "with",
+			log:      func(l Logger) { l.With(F(KeySource, "mqtt")).Info("Connected") },
+			expected: "2020-01-01T12:00:00Z INFO Connected source=mqtt\n",
+		},
+		{
+			name:     "below the level",
+			log:      func(l Logger) { l.Debug("Key pressed") },
+			expected: "",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			l, buf := newTestLog(Options{Level: LevelInfo})
+			tc.log(l)
+			if actual := buf.String(); actual != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSecretsAreRedacted(t *testing.T) {
+	keys := []string{"code", "Code", "new_code", "mqtt.password", "pin", "token", "payload"}
+	for _, key := range keys {
+		t.Run(key, func(t *testing.T) {
+			l, buf := newTestLog(Options{})
+			l.Info("Changed", F(key, "1234"))
+			expected := "2020-01-01T12:00:00Z INFO Changed " + key + "=[redacted]\n"
+			if actual := buf.String(); actual != expected {
+				t.Errorf("expected %q, got %q", expected, actual)
+			}
+		})
+	}
+}
+
+func TestJSON(t *testing.T) {
+	l, buf := newTestLog(Options{JSON: true})
+	l.With(F(KeySource, "keypad")).Warn("Incorrect code entered", F("failures", 3), F("code", "1234"))
+	var actual map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &actual); err != nil {
+		t.Fatalf("failed to unmarshal %q: %v", buf.String(), err)
+	}
+	expected := map[string]interface{}{
+		"time":     "2020-01-01T12:00:00Z",
+		"level":    "warn",
+		"msg":      "Incorrect code entered",
+		"source":   "keypad",
+		"failures": float64(3),
+		"code":     "[redacted]",
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected Level
+		err      bool
+	}{
+		{name: "debug", expected: LevelDebug},
+		{name: "INFO", expected: LevelInfo},
+		{name: "warn", expected: LevelWarn},
+		{name: "error", expected: LevelError},
+		{name: "verbose", expected: LevelInfo, err: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := ParseLevel(tc.name)
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if actual != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
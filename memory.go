@@ -0,0 +1,111 @@
+package alarm
+
+import (
+	"time"
+
+	"github.com/a-h/alarm/logging"
+)
+
+// memoryDisplay is shown on the display until the alarm memory is acknowledged.
+const memoryDisplay = "ALRM"
+
+// Memory of the alarm being triggered, kept until it is acknowledged.
+type Memory struct {
+	// Zones that caused the alarm. Empty if the alarm was triggered by a command.
+	Zones []string  `json:"zones,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// SirenStopped is sent when the siren has sounded for the SirenDuration.
+type SirenStopped struct {
+	Memory Memory
+	// Rearmed is true if the alarm was re-armed.
+	Rearmed bool
+	Time    time.Time
+}
+
+func (SirenStopped) event() {}
+
+// AcknowledgeMemory clears the alarm memory from the display. The memory can
+// only be acknowledged once the alarm is disarmed.
+func (a *Alarm) AcknowledgeMemory() {
+	a.do(a.acknowledgeMemory)
+}
+
+func (a *Alarm) acknowledgeMemory() {
+	if a.memory == nil || a.state != Disarmed {
+		return
+	}
+	a.Logger.Info("Alarm memory acknowledged", logging.F(logging.KeyUser, a.user))
+	a.memory = nil
+	a.display = a.idleDisplay()
+	a.save()
+}
+
+// idleDisplay is shown when nothing else is being displayed.
+func (a *Alarm) idleDisplay() string {
+	if a.memory != nil {
+		return memoryDisplay
+	}
+	return ""
+}
+
+// alarmZone records that a zone caused the alarm to be triggered.
+func (a *Alarm) alarmZone(name string) {
+	for _, z := range a.alarmZones {
+		if z == name {
+			return
+		}
+	}
+	a.alarmZones = append(a.alarmZones, name)
+}
+
+// remember the alarm being triggered.
+func (a *Alarm) remember() {
+	a.memory = &Memory{
+		Zones: append([]string(nil), a.alarmZones...),
+		Time:  time.Now(),
+	}
+}
+
+// stopSiren once the siren has sounded for the SirenDuration, then either
+// re-arm the alarm, or leave the alarm memory on the display. The alarm is only
+// re-armed if it was armed when it was triggered, so that a panic or a 24 hour
+// zone doesn't arm a disarmed alarm.
+func (a *Alarm) stopSiren() {
+	a.Logger.Info("Stopping the siren", logging.F("duration", a.SirenDuration))
+	a.StopAlarm()
+	var memory Memory
+	if a.memory != nil {
+		memory = *a.memory
+	}
+	rearm := a.Rearm && (a.triggeredFrom == Armed || a.triggeredFrom == Triggering)
+	a.emit(SirenStopped{Memory: memory, Rearmed: rearm, Time: time.Now()})
+	if !rearm {
+		a.display = a.idleDisplay()
+		return
+	}
+	// Zones that are still open would trigger the alarm again straight away.
+	for _, name := range memory.Zones {
+		if z, ok := a.zone(name); ok && z.Open && !z.Bypassed {
+			a.Logger.Warn("Bypassing zone until the alarm is disarmed, because it is still open", logging.F(logging.KeyZone, name))
+			z.Bypassed = true
+			z.rearmBypass = true
+			a.emit(ZoneChanged{Zone: *z, Time: time.Now()})
+		}
+	}
+	a.Logger.Info("Re-arming the alarm")
+	a.arm(CauseTimer)
+}
+
+// clearRearmBypasses removes the bypasses added when the alarm was re-armed.
+func (a *Alarm) clearRearmBypasses() {
+	for i := range a.zones {
+		z := &a.zones[i]
+		if z.rearmBypass {
+			z.Bypassed = false
+			z.rearmBypass = false
+			a.emit(ZoneChanged{Zone: *z, Time: time.Now()})
+		}
+	}
+}
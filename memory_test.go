@@ -0,0 +1,114 @@
+package alarm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRearmAfterSiren(t *testing.T) {
+	alarm := New("1234")
+	defer alarm.Close()
+	alarm.SirenDuration = time.Millisecond * 5
+	alarm.Rearm = true
+	alarm.AddZone(Zone{Name: "garage", Type: Instant})
+	alarm.Arm(Away)
+	alarm.SetZoneOpen("garage", true)
+	time.Sleep(time.Millisecond * 100)
+
+	status := alarm.Status()
+	if status.State != Armed {
+		t.Errorf("expected the alarm to re-arm, got state %v", status.State)
+	}
+	if !status.Zones[0].Bypassed {
+		t.Errorf("expected the open zone to be bypassed")
+	}
+	if status.Memory == nil || len(status.Memory.Zones) != 1 || status.Memory.Zones[0] != "garage" {
+		t.Errorf("expected the alarm memory to contain the garage, got %+v", status.Memory)
+	}
+
+	alarm.Disarm()
+	if status = alarm.Status(); status.Zones[0].Bypassed {
+		t.Errorf("expected the bypass to be removed when the alarm is disarmed")
+	}
+}
+
+func TestMemoryAfterSiren(t *testing.T) {
+	var actualAlarmStops int
+	alarm := New("1234")
+	defer alarm.Close()
+	alarm.SirenDuration = time.Millisecond * 5
+	alarm.StopAlarm = func() {
+		actualAlarmStops++
+	}
+	alarm.Trigger()
+	time.Sleep(time.Millisecond * 100)
+
+	status := alarm.Status()
+	if status.State != Triggered {
+		t.Errorf("expected state: %v, got %v", Triggered, status.State)
+	}
+	if status.Display != memoryDisplay {
+		t.Errorf("expected the display to show the alarm memory, got %q", status.Display)
+	}
+
+	// The first code disarms the alarm, the second acknowledges the memory.
+	if err := alarm.Execute(Command{State: Disarmed, Code: "1234"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status = alarm.Status(); status.State != Disarmed || status.Memory == nil {
+		t.Errorf("expected the alarm to be disarmed with the memory kept, got %+v", status)
+	}
+	if err := alarm.Execute(Command{State: Disarmed, Code: "1234"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status = alarm.Status(); status.Memory != nil || status.Display != "" {
+		t.Errorf("expected the memory to be acknowledged, got %+v", status)
+	}
+	if actualAlarmStops != 2 {
+		t.Errorf("expected alarm stops: 2, got %d", actualAlarmStops)
+	}
+}
+
+func TestAcknowledgeMemoryWhileTriggered(t *testing.T) {
+	alarm := New("1234")
+	defer alarm.Close()
+	alarm.SirenDuration = time.Millisecond * 5
+	alarm.Trigger()
+	alarm.AcknowledgeMemory()
+	time.Sleep(time.Millisecond * 100)
+
+	status := alarm.Status()
+	if status.Memory == nil {
+		t.Errorf("expected the memory to be kept until the alarm is disarmed")
+	}
+	if status.Display != memoryDisplay {
+		t.Errorf("expected the display to show the alarm memory, got %q", status.Display)
+	}
+}
+
+func TestNoRearmIfTriggeredWhileDisarmed(t *testing.T) {
+	alarm := New("1234")
+	defer alarm.Close()
+	alarm.SirenDuration = time.Millisecond * 5
+	alarm.Rearm = true
+	events, unsubscribe := alarm.Subscribe()
+	defer unsubscribe()
+	alarm.Trigger()
+	time.Sleep(time.Millisecond * 100)
+
+	status := alarm.Status()
+	if status.State != Triggered {
+		t.Errorf("expected the alarm not to re-arm, got state %v", StateNames[status.State])
+	}
+	if status.Display != memoryDisplay {
+		t.Errorf("expected the display to show the alarm memory, got %q", status.Display)
+	}
+	for e := range events {
+		if s, ok := e.(SirenStopped); ok {
+			if s.Rearmed {
+				t.Error("expected the siren stopped event not to be re-armed")
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package alarm
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/a-h/alarm/logging"
+)
+
+// Store persists the alarm between restarts.
+type Store interface {
+	// Load the snapshot. If nothing has been saved yet, the error satisfies
+	// os.IsNotExist.
+	Load() (Snapshot, error)
+	Save(Snapshot) error
+}
+
+// Snapshot of the alarm that is persisted to a Store.
+type Snapshot struct {
+	State State  `json:"state"`
+	Mode  Mode   `json:"mode"`
+	Users []User `json:"users"`
+	// Bypassed zone names.
+	Bypassed []string `json:"bypassed,omitempty"`
+	// RearmBypassed zone names were bypassed when the alarm re-armed, and are
+	// monitored again once the alarm is disarmed.
+	RearmBypassed []string  `json:"rearmBypassed,omitempty"`
+	Failures      int       `json:"failures"`
+	Lockouts      int       `json:"lockouts"`
+	LockedUntil   time.Time `json:"lockedUntil,omitempty"`
+	Memory        *Memory   `json:"memory,omitempty"`
+	// TriggeredFrom is the state the alarm was in when it was triggered.
+	TriggeredFrom State `json:"triggeredFrom,omitempty"`
+}
+
+// FileStore stores the snapshot as JSON in a file.
+type FileStore struct {
+	Path string
+}
+
+// Load the snapshot from the file.
+func (fs FileStore) Load() (s Snapshot, err error) {
+	data, err := ioutil.ReadFile(fs.Path)
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(data, &s)
+	return s, err
+}
+
+// Save the snapshot to the file. The file is replaced atomically, so a power
+// cut part way through a save leaves the previous snapshot intact.
+func (fs FileStore) Save(s Snapshot) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(fs.Path), filepath.Base(fs.Path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), fs.Path); err != nil {
+		return err
+	}
+	// Sync the directory so that the rename survives a power cut.
+	dir, err := os.Open(filepath.Dir(fs.Path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// Restore the alarm from the store, and save changes to the store from then
+// on. If nothing has been saved to the store yet, the alarm is saved instead.
+//
+// The callbacks must be set and the zones added before the alarm is restored,
+// since a restored alarm resumes its previous state, e.g. sounding the alarm
+// if it was triggered.
+func (a *Alarm) Restore(store Store) (err error) {
+	a.do(func() {
+		var s Snapshot
+		s, err = store.Load()
+		if os.IsNotExist(err) {
+			if len(a.users) == 0 {
+				err = errors.New("alarm: no users to save to the new store")
+				return
+			}
+			a.store = store
+			err = store.Save(a.snapshot())
+			return
+		}
+		if err != nil {
+			return
+		}
+		a.users = s.Users
+		a.failures = s.Failures
+		a.lockouts = s.Lockouts
+		a.lockedUntil = s.LockedUntil
+		a.memory = s.Memory
+		if s.Memory != nil {
+			a.alarmZones = s.Memory.Zones
+		}
+		a.display = a.idleDisplay()
+		for _, name := range s.Bypassed {
+			z, ok := a.zone(name)
+			if !ok {
+				a.Logger.Warn("Cannot restore bypass of unknown zone", logging.F(logging.KeyZone, name))
+				continue
+			}
+			z.Bypassed = true
+		}
+		for _, name := range s.RearmBypassed {
+			z, ok := a.zone(name)
+			if !ok {
+				a.Logger.Warn("Cannot restore re-arm bypass of unknown zone", logging.F(logging.KeyZone, name))
+				continue
+			}
+			z.Bypassed = true
+			z.rearmBypass = true
+		}
+		a.store = store
+		a.Logger.Info("Restoring the alarm", logging.F(logging.KeyState, StateNames[s.State]), logging.F(logging.KeyMode, ModeNames[s.Mode]))
+		switch s.State {
+		case Arming:
+			a.arming(s.Mode, CauseRestore)
+		case Armed:
+			a.mode = s.Mode
+			a.arm(CauseRestore)
+		case Triggering:
+			a.mode = s.Mode
+			a.triggering(a.EntryDelay, CauseRestore)
+		case Triggered:
+			a.mode = s.Mode
+			a.trigger(CauseRestore)
+			a.triggeredFrom = s.TriggeredFrom
+			a.save()
+		}
+	})
+	return
+}
+
+func (a *Alarm) snapshot() Snapshot {
+	s := Snapshot{
+		State:       a.state,
+		Mode:        a.mode,
+		Users:       a.users,
+		Failures:    a.failures,
+		Lockouts:    a.lockouts,
+		LockedUntil: a.lockedUntil,
+		Memory:      a.memory,
+	}
+	if a.state == Triggered {
+		s.TriggeredFrom = a.triggeredFrom
+	}
+	for _, z := range a.zones {
+		switch {
+		case z.rearmBypass:
+			s.RearmBypassed = append(s.RearmBypassed, z.Name)
+		case z.Bypassed:
+			s.Bypassed = append(s.Bypassed, z.Name)
+		}
+	}
+	return s
+}
+
+// save the alarm to the store, if there is one.
+func (a *Alarm) save() {
+	if a.store == nil {
+		return
+	}
+	if err := a.store.Save(a.snapshot()); err != nil {
+		a.Logger.Error("Failed to save the alarm", logging.Err(err))
+	}
+}
@@ -0,0 +1,133 @@
+package alarm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	fs := FileStore{Path: filepath.Join(t.TempDir(), "alarm.json")}
+	if _, err := fs.Load(); !os.IsNotExist(err) {
+		t.Fatalf("expected a not exist error before saving, got %v", err)
+	}
+	expected := Snapshot{Users: []User{{Name: "admin", Role: RoleAdmin, Hash: []byte("hash")}}}
+	if err := fs.Save(expected); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	actual, err := fs.Load()
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if len(actual.Users) != 1 || actual.Users[0].Name != "admin" || string(actual.Users[0].Hash) != "hash" {
+		t.Errorf("expected %+v, got %+v", expected, actual)
+	}
+}
+
+func TestRestoreCodeChange(t *testing.T) {
+	fs := FileStore{Path: filepath.Join(t.TempDir(), "alarm.json")}
+
+	alarm := New("1234")
+	if err := alarm.Restore(fs); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	for _, k := range "B1234B4321#" {
+		alarm.KeyPressed(string(k))
+	}
+	alarm.Close()
+
+	// The restored alarm has the users from the store, and no admin of its own.
+	restored := New("")
+	defer restored.Close()
+	if err := restored.Restore(fs); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	if err := restored.Execute(Command{State: Arming, Code: "4321"}); err != nil {
+		t.Errorf("expected the changed code to be restored, got %v", err)
+	}
+}
+
+func TestRestoreWithoutUsers(t *testing.T) {
+	alarm := New("")
+	defer alarm.Close()
+	if err := alarm.Restore(FileStore{Path: filepath.Join(t.TempDir(), "alarm.json")}); err == nil {
+		t.Errorf("expected an error when there are no users to save")
+	}
+}
+
+func TestRestoreState(t *testing.T) {
+	fs := FileStore{Path: filepath.Join(t.TempDir(), "alarm.json")}
+
+	alarm := New("1234")
+	alarm.AddZone(Zone{Name: "garage", Type: Instant})
+	if err := alarm.Restore(fs); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	alarm.BypassZone("garage", true)
+	if err := alarm.Execute(Command{State: Arming, Mode: Home, Code: "1234"}); err != nil {
+		t.Fatalf("failed to arm: %v", err)
+	}
+	if err := alarm.Execute(Command{State: Disarmed, Code: "0000"}); err != ErrBadCode {
+		t.Fatalf("expected an incorrect code error, got %v", err)
+	}
+	alarm.Trigger()
+	alarm.Close()
+
+	var actualAlarmStarts int
+	restored := New("")
+	restored.StartAlarm = func() {
+		actualAlarmStarts++
+	}
+	restored.AddZone(Zone{Name: "garage", Type: Instant})
+	if err := restored.Restore(fs); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	status := restored.Status()
+	restored.Close()
+	if status.State != Triggered {
+		t.Errorf("expected state: %v, got %v", Triggered, status.State)
+	}
+	if status.Mode != Home {
+		t.Errorf("expected mode: %v, got %v", ModeNames[Home], ModeNames[status.Mode])
+	}
+	if actualAlarmStarts != 1 {
+		t.Errorf("expected the restored alarm to start sounding, got %d alarm starts", actualAlarmStarts)
+	}
+	if status.Failures != 1 {
+		t.Errorf("expected failures: 1, got %d", status.Failures)
+	}
+	if len(status.Zones) != 1 || !status.Zones[0].Bypassed {
+		t.Errorf("expected the zone bypass to be restored, got %+v", status.Zones)
+	}
+}
+
+func TestRestoreRearmBypass(t *testing.T) {
+	fs := FileStore{Path: filepath.Join(t.TempDir(), "alarm.json")}
+
+	alarm := New("1234")
+	alarm.SirenDuration = time.Millisecond * 5
+	alarm.Rearm = true
+	alarm.AddZone(Zone{Name: "garage", Type: Instant})
+	if err := alarm.Restore(fs); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	alarm.Arm(Away)
+	alarm.SetZoneOpen("garage", true)
+	time.Sleep(time.Millisecond * 100)
+	alarm.Close()
+
+	restored := New("")
+	defer restored.Close()
+	restored.AddZone(Zone{Name: "garage", Type: Instant})
+	if err := restored.Restore(fs); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	if status := restored.Status(); status.State != Armed || !status.Zones[0].Bypassed {
+		t.Fatalf("expected the alarm to be re-armed with the zone bypassed, got %+v", status)
+	}
+	restored.Disarm()
+	if status := restored.Status(); status.Zones[0].Bypassed {
+		t.Errorf("expected the re-arm bypass to be removed when the alarm is disarmed")
+	}
+}
@@ -0,0 +1,317 @@
+package alarm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/a-h/alarm/logging"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Role determines what a user is allowed to do.
+type Role int
+
+const (
+	// RoleAdmin users can arm and disarm the alarm, and manage users.
+	RoleAdmin Role = iota
+	// RoleUser users can arm and disarm the alarm.
+	RoleUser
+	// RoleGuest users can arm and disarm the alarm, and are expected to have a
+	// validity window or schedule.
+	RoleGuest
+	// RoleDuress codes are used by a user who is being forced to disarm the
+	// alarm. The alarm behaves as normal, but a Duress event is sent.
+	RoleDuress
+)
+
+// RoleNames contains the names of the various roles.
+var RoleNames = map[Role]string{
+	RoleAdmin:  "Admin",
+	RoleUser:   "User",
+	RoleGuest:  "Guest",
+	RoleDuress: "Duress",
+}
+
+var (
+	// ErrCodeNotValid is returned when a code is used outside of its validity
+	// window or schedule.
+	ErrCodeNotValid = errors.New("alarm: code is not valid at this time")
+	// ErrNotAllowed is returned when a user's role doesn't permit the command.
+	ErrNotAllowed = errors.New("alarm: user is not allowed to do that")
+	// ErrUnknownUser is returned when a user does not exist.
+	ErrUnknownUser = errors.New("alarm: unknown user")
+	// ErrLastAdmin is returned when removing the only admin user.
+	ErrLastAdmin = errors.New("alarm: cannot remove the last admin user")
+)
+
+// User of the alarm.
+type User struct {
+	Name string `json:"name"`
+	Role Role   `json:"role"`
+	// Hash of the user's code.
+	Hash []byte `json:"hash"`
+	// ValidFrom and ValidUntil restrict the code to a time window. Zero values
+	// are unbounded.
+	ValidFrom  time.Time `json:"validFrom,omitempty"`
+	ValidUntil time.Time `json:"validUntil,omitempty"`
+	// Schedule restricts the code to certain times of day.
+	Schedule *Schedule `json:"schedule,omitempty"`
+}
+
+// codeCost is the bcrypt cost used to hash codes. It's below
+// bcrypt.DefaultCost, since every user's hash is checked each time a code is
+// entered.
+const codeCost = 6
+
+// hashCost is the cost used to hash new codes. The tests lower it.
+var hashCost = codeCost
+
+// HashCode returns the bcrypt hash of the code, which can be given to
+// AddUserWithHash instead of the code, e.g. in a config file.
+func HashCode(code string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(code), hashCost)
+}
+
+// hasCode returns true if the code matches the user's hash. The comparison
+// takes constant time.
+func (u User) hasCode(code string) bool {
+	return bcrypt.CompareHashAndPassword(u.Hash, []byte(code)) == nil
+}
+
+// ValidAt returns true if the user's code can be used at t.
+func (u User) ValidAt(t time.Time) bool {
+	if !u.ValidFrom.IsZero() && t.Before(u.ValidFrom) {
+		return false
+	}
+	if !u.ValidUntil.IsZero() && !t.Before(u.ValidUntil) {
+		return false
+	}
+	return u.Schedule == nil || u.Schedule.Allows(t)
+}
+
+// Schedule restricts a code to certain times of day.
+type Schedule struct {
+	// Days the code can be used on. Empty means every day.
+	Days []time.Weekday `json:"days,omitempty"`
+	// Start and End are times of day, e.g. 9 * time.Hour for 09:00. If End is
+	// before Start, the schedule runs overnight.
+	Start time.Duration `json:"start"`
+	End   time.Duration `json:"end"`
+}
+
+// Allows returns true if t is within the schedule.
+func (s Schedule) Allows(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	timeOfDay := t.Sub(midnight)
+	day := t.Weekday()
+	if s.End < s.Start && timeOfDay < s.End {
+		// The early hours of an overnight schedule belong to the previous day.
+		day = (day + 6) % 7
+	}
+	if !s.allowsDay(day) {
+		return false
+	}
+	if s.End < s.Start {
+		return timeOfDay >= s.Start || timeOfDay < s.End
+	}
+	return timeOfDay >= s.Start && timeOfDay < s.End
+}
+
+func (s Schedule) allowsDay(day time.Weekday) bool {
+	if len(s.Days) == 0 {
+		return true
+	}
+	for _, d := range s.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// UserChanged is sent when a user is added, removed or has their code changed.
+type UserChanged struct {
+	Name    string
+	Added   bool
+	Removed bool
+	// By is the name of the user that made the change.
+	By   string
+	Time time.Time
+}
+
+func (UserChanged) event() {}
+
+// Duress is sent when a duress code is used. It should raise a silent alert.
+type Duress struct {
+	User string
+	// State the user was forced to put the alarm into.
+	State State
+	Time  time.Time
+}
+
+func (Duress) event() {}
+
+// AddUser adds a user with the code to the alarm. Names and codes must be
+// unique.
+func (a *Alarm) AddUser(u User, code string) (err error) {
+	u.Hash = nil
+	a.do(func() {
+		err = a.addUser(u, code, "")
+	})
+	return
+}
+
+// AddUserWithHash adds a user whose Hash is already set to the bcrypt hash of
+// their code, so that the code itself doesn't need to be known. Names must be
+// unique, but since the code isn't known, it can't be checked against the
+// other users' codes.
+func (a *Alarm) AddUserWithHash(u User) (err error) {
+	if _, err = bcrypt.Cost(u.Hash); err != nil {
+		return fmt.Errorf("alarm: invalid hash for user %q: %w", u.Name, err)
+	}
+	a.do(func() {
+		err = a.addUser(u, "", "")
+	})
+	return
+}
+
+// addUser adds the user with the code, or with their existing hash if the
+// hash is set.
+func (a *Alarm) addUser(u User, code, by string) (err error) {
+	if u.Name == "" {
+		return errors.New("alarm: user name is required")
+	}
+	if _, ok := a.userIndex(u.Name); ok {
+		return fmt.Errorf("alarm: user %q already exists", u.Name)
+	}
+	if u.Hash == nil {
+		if err = a.checkCodeIsUnused(code, ""); err != nil {
+			return err
+		}
+		if u.Hash, err = HashCode(code); err != nil {
+			return err
+		}
+	}
+	a.users = append(a.users, u)
+	a.Logger.Info("Added user", logging.F(logging.KeyUser, u.Name), logging.F("role", RoleNames[u.Role]), logging.F("by", by))
+	a.emit(UserChanged{Name: u.Name, Added: true, By: by, Time: time.Now()})
+	a.save()
+	return nil
+}
+
+// checkCodeIsUnused returns an error if the code is empty, or belongs to a
+// user other than the named user.
+func (a *Alarm) checkCodeIsUnused(code, name string) error {
+	if code == "" {
+		return errors.New("alarm: user code is required")
+	}
+	for _, u := range a.users {
+		if u.Name != name && u.hasCode(code) {
+			return errors.New("alarm: code is already in use")
+		}
+	}
+	return nil
+}
+
+// RemoveUser removes a user from the alarm.
+func (a *Alarm) RemoveUser(name string) (err error) {
+	a.do(func() {
+		err = a.removeUser(name, "")
+	})
+	return
+}
+
+func (a *Alarm) removeUser(name, by string) error {
+	i, ok := a.userIndex(name)
+	if !ok {
+		return ErrUnknownUser
+	}
+	if a.users[i].Role == RoleAdmin && a.admins() == 1 {
+		return ErrLastAdmin
+	}
+	a.users = append(a.users[:i], a.users[i+1:]...)
+	a.Logger.Info("Removed user", logging.F(logging.KeyUser, name), logging.F("by", by))
+	a.emit(UserChanged{Name: name, Removed: true, By: by, Time: time.Now()})
+	a.save()
+	return nil
+}
+
+// Users returns the users of the alarm, without their code hashes.
+func (a *Alarm) Users() (users []User) {
+	a.do(func() {
+		users = make([]User, len(a.users))
+		for i, u := range a.users {
+			u.Hash = nil
+			users[i] = u
+		}
+	})
+	return
+}
+
+func (a *Alarm) userIndex(name string) (int, bool) {
+	for i, u := range a.users {
+		if u.Name == name {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+func (a *Alarm) admins() (n int) {
+	for _, u := range a.users {
+		if u.Role == RoleAdmin {
+			n++
+		}
+	}
+	return
+}
+
+// manageUsers runs a keypad user management command entered by an admin.
+// B<code>B<new code># changes the admin's code, B<code>A<new code># adds a
+// user and B<code>D<user code># removes the user with that code.
+func (a *Alarm) manageUsers(code, action, argument string) error {
+	admin, err := a.checkCode(code, CauseKeypad)
+	if err != nil {
+		return err
+	}
+	if admin.Role != RoleAdmin {
+		return ErrNotAllowed
+	}
+	switch action {
+	case "B":
+		if err = a.checkCodeIsUnused(argument, admin.Name); err != nil {
+			return err
+		}
+		hash, err := HashCode(argument)
+		if err != nil {
+			return err
+		}
+		i, _ := a.userIndex(admin.Name)
+		a.users[i].Hash = hash
+		a.Logger.Info("Changed the code", logging.F(logging.KeyUser, admin.Name))
+		a.emit(UserChanged{Name: admin.Name, By: admin.Name, Time: time.Now()})
+		a.save()
+		return nil
+	case "A":
+		return a.addUser(User{Name: a.nextUserName(), Role: RoleUser}, argument, admin.Name)
+	case "D":
+		for _, u := range a.users {
+			if u.hasCode(argument) {
+				return a.removeUser(u.Name, admin.Name)
+			}
+		}
+		return ErrUnknownUser
+	}
+	return nil
+}
+
+// nextUserName returns an unused name for a user added from the keypad.
+func (a *Alarm) nextUserName() string {
+	for i := len(a.users) + 1; ; i++ {
+		name := fmt.Sprintf("user %d", i)
+		if _, ok := a.userIndex(name); !ok {
+			return name
+		}
+	}
+}
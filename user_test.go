@@ -0,0 +1,233 @@
+package alarm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-h/alarm/logging"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestSchedule(t *testing.T) {
+	// 2021-03-01 was a Monday.
+	at := func(day, hour int) time.Time {
+		return time.Date(2021, time.March, day, hour, 30, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name     string
+		schedule Schedule
+		t        time.Time
+		expected bool
+	}{
+		{
+			name:     "within a daytime schedule",
+			schedule: Schedule{Start: 9 * time.Hour, End: 17 * time.Hour},
+			t:        at(1, 10),
+			expected: true,
+		},
+		{
+			name:     "after a daytime schedule",
+			schedule: Schedule{Start: 9 * time.Hour, End: 17 * time.Hour},
+			t:        at(1, 17),
+			expected: false,
+		},
+		{
+			name:     "on a day that isn't in the schedule",
+			schedule: Schedule{Days: []time.Weekday{time.Saturday, time.Sunday}, Start: 9 * time.Hour, End: 17 * time.Hour},
+			t:        at(1, 10),
+			expected: false,
+		},
+		{
+			name:     "late in an overnight schedule",
+			schedule: Schedule{Days: []time.Weekday{time.Monday}, Start: 22 * time.Hour, End: 6 * time.Hour},
+			t:        at(1, 23),
+			expected: true,
+		},
+		{
+			name:     "early the next morning in an overnight schedule",
+			schedule: Schedule{Days: []time.Weekday{time.Monday}, Start: 22 * time.Hour, End: 6 * time.Hour},
+			t:        at(2, 5),
+			expected: true,
+		},
+		{
+			name:     "early on the scheduled day of an overnight schedule",
+			schedule: Schedule{Days: []time.Weekday{time.Monday}, Start: 22 * time.Hour, End: 6 * time.Hour},
+			t:        at(1, 5),
+			expected: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.schedule.Allows(test.t); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestUserCodes(t *testing.T) {
+	alarm := New("1234")
+	defer alarm.Close()
+	events, unsubscribe := alarm.Subscribe()
+	defer unsubscribe()
+
+	// Add a user with the admin code.
+	for _, k := range "B1234A5555#" {
+		alarm.KeyPressed(string(k))
+	}
+	if users := alarm.Users(); len(users) != 2 || users[1].Role != RoleUser {
+		t.Fatalf("expected a user to be added, got %+v", users)
+	}
+
+	// Users can't manage other users.
+	for _, k := range "B5555A6666#" {
+		alarm.KeyPressed(string(k))
+	}
+	if users := alarm.Users(); len(users) != 2 {
+		t.Errorf("expected users to be unable to add users, got %+v", users)
+	}
+
+	// The new user can arm the alarm, and is recorded against the transition.
+	if err := alarm.Execute(Command{State: Arming, Code: "5555"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	alarm.Disarm()
+	for e := range events {
+		if tr, ok := e.(Transition); ok {
+			if tr.To != Arming || tr.User != "user 2" {
+				t.Errorf("expected user 2 to arm the alarm, got %+v", tr)
+			}
+			break
+		}
+	}
+
+	// Remove the user.
+	for _, k := range "B1234D5555#" {
+		alarm.KeyPressed(string(k))
+	}
+	if users := alarm.Users(); len(users) != 1 {
+		t.Errorf("expected the user to be removed, got %+v", users)
+	}
+	if err := alarm.Execute(Command{State: Arming, Code: "5555"}); err != ErrBadCode {
+		t.Errorf("expected the removed code to be rejected, got %v", err)
+	}
+}
+
+func TestCodesAreNotLogged(t *testing.T) {
+	alarm := New("1234")
+	defer alarm.Close()
+	var buf bytes.Buffer
+	alarm.Logger = logging.New(&buf, logging.Options{Level: logging.LevelDebug})
+
+	// Change the admin code, add a user, and enter an incorrect code.
+	for _, k := range "B1234B2468#B2468A5555#A9753#" {
+		alarm.KeyPressed(string(k))
+	}
+	alarm.Close()
+	log := buf.String()
+	if log == "" {
+		t.Fatal("expected messages to be logged")
+	}
+	for _, code := range []string{"1234", "2468", "5555", "9753"} {
+		if strings.Contains(log, code) {
+			t.Errorf("expected code %s not to be logged, got:\n%s", code, log)
+		}
+	}
+}
+
+func TestExpiredCode(t *testing.T) {
+	alarm := New("1234")
+	defer alarm.Close()
+	err := alarm.AddUser(User{
+		Name:       "cleaner",
+		Role:       RoleGuest,
+		ValidUntil: time.Now().Add(-time.Hour),
+	}, "2468")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = alarm.Execute(Command{State: Arming, Code: "2468"}); err != ErrCodeNotValid {
+		t.Errorf("expected the expired code to be rejected, got %v", err)
+	}
+	if failures := alarm.Status().Failures; failures != 1 {
+		t.Errorf("expected the expired code to count as a failure, got %d", failures)
+	}
+}
+
+func TestAddUserWithHash(t *testing.T) {
+	alarm := New("1234")
+	defer alarm.Close()
+	if err := alarm.AddUserWithHash(User{Name: "cleaner", Role: RoleUser, Hash: []byte("2468")}); err == nil {
+		t.Error("expected an error for a hash that isn't a bcrypt hash")
+	}
+	hash, err := HashCode("2468")
+	if err != nil {
+		t.Fatalf("failed to hash code: %v", err)
+	}
+	if err = alarm.AddUserWithHash(User{Name: "cleaner", Role: RoleUser, Hash: hash}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = alarm.Execute(Command{State: Arming, Code: "2468"}); err != nil {
+		t.Errorf("expected the user's code to arm the alarm, got %v", err)
+	}
+}
+
+func TestRemoveLastAdmin(t *testing.T) {
+	alarm := New("1234")
+	defer alarm.Close()
+	if err := alarm.RemoveUser("admin"); err != ErrLastAdmin {
+		t.Errorf("expected an error removing the last admin, got %v", err)
+	}
+}
+
+func TestDuressCode(t *testing.T) {
+	var actualStopAlarms int
+	alarm := New("1234")
+	alarm.state = Triggered
+	alarm.StopAlarm = func() {
+		actualStopAlarms++
+	}
+	events, unsubscribe := alarm.Subscribe()
+	defer unsubscribe()
+	if err := alarm.AddUser(User{Name: "duress", Role: RoleDuress}, "9999"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, k := range "D9999#" {
+		alarm.KeyPressed(string(k))
+	}
+	status := alarm.Status()
+	alarm.Close()
+
+	if status.State != Disarmed {
+		t.Errorf("expected state: %v, got %v", Disarmed, status.State)
+	}
+	if actualStopAlarms != 1 {
+		t.Errorf("expected alarm stops: 1, got %d", actualStopAlarms)
+	}
+	var duress []Duress
+	for e := range events {
+		if d, ok := e.(Duress); ok {
+			duress = append(duress, d)
+		}
+	}
+	if len(duress) != 1 || duress[0].User != "duress" || duress[0].State != Disarmed {
+		t.Errorf("expected a duress event, got %+v", duress)
+	}
+}
+
+// BenchmarkHasCode measures checking a code at codeCost. To run it on the Pi,
+// build it with GOOS=linux GOARCH=arm GOARM=5 go test -c, and run the binary
+// with -test.run none -test.bench HasCode.
+func BenchmarkHasCode(b *testing.B) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("1234"), codeCost)
+	if err != nil {
+		b.Fatalf("failed to hash code: %v", err)
+	}
+	u := User{Name: "user", Hash: hash}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		u.hasCode("1234")
+	}
+}
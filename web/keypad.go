@@ -0,0 +1,73 @@
+package web
+
+import (
+	"net/http"
+)
+
+// keypad serves a page that works like the physical keypad.
+func (s *Server) keypad(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(keypadPage))
+}
+
+const keypadPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Alarm</title>
+<style>
+body { font-family: sans-serif; background: #222; color: #eee; display: flex; flex-direction: column; align-items: center; }
+#display { font-family: monospace; font-size: 3em; background: #000; color: #f33; width: 4.5em; text-align: right; padding: 0.2em; margin: 0.5em; }
+#state { margin-bottom: 0.5em; }
+#keys { display: grid; grid-template-columns: repeat(4, 4em); gap: 0.5em; }
+button { font-size: 1.5em; height: 2.5em; }
+ul { list-style: none; padding: 0; }
+.open { color: #f93; }
+</style>
+</head>
+<body>
+<div id="display"></div>
+<div id="state"></div>
+<div id="keys"></div>
+<ul id="zones"></ul>
+<script>
+const rows = ["123A", "456B", "789C", "*0#D"];
+const keys = document.getElementById("keys");
+for (const row of rows) {
+	for (const key of row) {
+		const button = document.createElement("button");
+		button.textContent = key;
+		button.onclick = () => post(key);
+		keys.appendChild(button);
+	}
+}
+function show(status) {
+	document.getElementById("display").textContent = status.display.slice(-4) || " ";
+	document.getElementById("state").textContent = status.state + (status.state === "Disarmed" ? "" : " (" + status.mode + ")");
+	const zones = document.getElementById("zones");
+	zones.innerHTML = "";
+	for (const z of status.zones) {
+		const li = document.createElement("li");
+		li.textContent = z.name + ": " + (z.open ? "open" : "closed") + (z.bypassed ? " (bypassed)" : "");
+		li.className = z.open ? "open" : "";
+		zones.appendChild(li);
+	}
+}
+function post(key) {
+	fetch("/api/keys", { method: "POST", body: JSON.stringify({ keys: key }) })
+		.then(r => r.json()).then(show);
+}
+function refresh() {
+	fetch("/api/status").then(r => r.json()).then(show).catch(() => {});
+}
+refresh();
+setInterval(refresh, 1000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,314 @@
+// Package web serves a REST API and a keypad page, so that the alarm can be
+// managed on the local network when the MQTT broker is down.
+package web
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a-h/alarm"
+	"github.com/a-h/alarm/journal"
+	"github.com/a-h/alarm/logging"
+)
+
+// defaultLimit is the number of events returned if no limit is given.
+const defaultLimit = 100
+
+// Options configure the server.
+type Options struct {
+	// Token can be sent as a bearer token instead of a code. If it's empty,
+	// a code is always required.
+	Token string
+	// Code is the alarm code used for requests authenticated with the token.
+	// It should belong to a user added for the API.
+	Code string
+	// Journal is queried for the event history. If it's nil, there's no
+	// history.
+	Journal Journal
+	// Logger receives the errors that can't be returned to the caller.
+	// Defaults to no logging.
+	Logger logging.Logger
+}
+
+// Journal is the journal of the alarm's events.
+type Journal interface {
+	Query(q journal.Query) ([]journal.Entry, error)
+}
+
+// Server serves the REST API and keypad page.
+type Server struct {
+	alarm *alarm.Alarm
+	opts  Options
+	mux   *http.ServeMux
+}
+
+// New creates a server for the alarm.
+func New(a *alarm.Alarm, opts Options) (*Server, error) {
+	if opts.Token != "" && opts.Code == "" {
+		return nil, errors.New("web: a code is required to use a token")
+	}
+	if opts.Logger == nil {
+		opts.Logger = logging.Nop{}
+	}
+	s := &Server{
+		alarm: a,
+		opts:  opts,
+		mux:   http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/", s.keypad)
+	s.mux.HandleFunc("/api/status", s.status)
+	s.mux.HandleFunc("/api/events", s.events)
+	s.mux.HandleFunc("/api/keys", s.keys)
+	s.mux.HandleFunc("/api/arm", s.arm)
+	s.mux.HandleFunc("/api/disarm", s.command(alarm.Disarmed))
+	s.mux.HandleFunc("/api/trigger", s.command(alarm.Triggered))
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// StatusResponse is returned by GET /api/status.
+type StatusResponse struct {
+	State       string        `json:"state"`
+	Mode        string        `json:"mode"`
+	Display     string        `json:"display"`
+	Failures    int           `json:"failures"`
+	LockedUntil *time.Time    `json:"lockedUntil,omitempty"`
+	Memory      *alarm.Memory `json:"memory,omitempty"`
+	Zones       []Zone        `json:"zones"`
+}
+
+// Zone in the StatusResponse.
+type Zone struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Open     bool   `json:"open"`
+	Bypassed bool   `json:"bypassed"`
+}
+
+// newStatusResponse creates the response for the status. Unless the caller has
+// the token, the digits are masked while the display shows the keys that were
+// pressed, since they may be a code.
+func newStatusResponse(status alarm.Status, authenticated bool) StatusResponse {
+	display := status.Display
+	if status.ShowingKeys && !authenticated {
+		display = maskDigits(display)
+	}
+	r := StatusResponse{
+		State:    alarm.StateNames[status.State],
+		Mode:     alarm.ModeNames[status.Mode],
+		Display:  display,
+		Failures: status.Failures,
+		Memory:   status.Memory,
+		Zones:    []Zone{},
+	}
+	if status.LockedUntil.After(time.Now()) {
+		r.LockedUntil = &status.LockedUntil
+	}
+	for _, z := range status.Zones {
+		r.Zones = append(r.Zones, Zone{Name: z.Name, Type: alarm.ZoneTypeNames[z.Type], Open: z.Open, Bypassed: z.Bypassed})
+	}
+	return r
+}
+
+func maskDigits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return '*'
+		}
+		return r
+	}, s)
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	s.writeJSON(w, http.StatusOK, newStatusResponse(s.alarm.Status(), s.hasToken(r)))
+}
+
+// events returns the most recent events from the journal. The from and to
+// query parameters are RFC 3339 times, type can be repeated, and limit is the
+// number of events, which defaults to 100. The history shows when the house is
+// empty, so the token is required.
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	if !s.hasToken(r) {
+		s.writeError(w, http.StatusUnauthorized, errors.New("a bearer token is required"))
+		return
+	}
+	if s.opts.Journal == nil {
+		s.writeError(w, http.StatusNotFound, errors.New("there is no journal"))
+		return
+	}
+	q, err := parseQuery(r.URL.Query())
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	entries, err := s.opts.Journal.Query(q)
+	if err != nil {
+		s.opts.Logger.Error("Failed to query the journal", logging.Err(err))
+		s.writeError(w, http.StatusInternalServerError, errors.New("failed to query the journal"))
+		return
+	}
+	if entries == nil {
+		entries = []journal.Entry{}
+	}
+	s.writeJSON(w, http.StatusOK, entries)
+}
+
+func parseQuery(values url.Values) (q journal.Query, err error) {
+	q.Limit = defaultLimit
+	if v := values.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 0 {
+			return q, errors.New("invalid limit")
+		}
+	}
+	if v := values.Get("from"); v != "" {
+		if q.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if v := values.Get("to"); v != "" {
+		if q.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+	for _, t := range values["type"] {
+		q.Types = append(q.Types, journal.Type(t))
+	}
+	return q, nil
+}
+
+// KeysRequest is sent to POST /api/keys by the keypad page.
+type KeysRequest struct {
+	Keys string `json:"keys"`
+}
+
+// keys presses the keys, as if they had been pressed on the keypad.
+func (s *Server) keys(w http.ResponseWriter, r *http.Request) {
+	var req KeysRequest
+	if !s.readJSON(w, r, &req) {
+		return
+	}
+	for _, k := range req.Keys {
+		s.alarm.KeyPressed(string(k))
+	}
+	s.writeJSON(w, http.StatusOK, newStatusResponse(s.alarm.Status(), s.hasToken(r)))
+}
+
+// CommandRequest is sent to POST /api/arm, /api/disarm and /api/trigger. The
+// code can be left out if a bearer token is sent.
+type CommandRequest struct {
+	// Mode is away, home or night.
+	Mode string `json:"mode,omitempty"`
+	Code string `json:"code,omitempty"`
+}
+
+var modes = map[string]alarm.Mode{
+	"away":  alarm.Away,
+	"home":  alarm.Home,
+	"night": alarm.Night,
+}
+
+// arm starts the exit delay.
+func (s *Server) arm(w http.ResponseWriter, r *http.Request) {
+	var req CommandRequest
+	if !s.readJSON(w, r, &req) {
+		return
+	}
+	mode, ok := modes[strings.ToLower(req.Mode)]
+	if !ok {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("unknown mode %q", req.Mode))
+		return
+	}
+	s.execute(w, r, alarm.Command{State: alarm.Arming, Mode: mode, Code: req.Code})
+}
+
+func (s *Server) command(state alarm.State) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CommandRequest
+		if !s.readJSON(w, r, &req) {
+			return
+		}
+		s.execute(w, r, alarm.Command{State: state, Code: req.Code})
+	}
+}
+
+func (s *Server) execute(w http.ResponseWriter, r *http.Request, c alarm.Command) {
+	if c.Code == "" && s.hasToken(r) {
+		c.Code = s.opts.Code
+	}
+	if err := s.alarm.Execute(c); err != nil {
+		s.opts.Logger.Warn("Failed to execute command from the web", logging.F(logging.KeyState, alarm.StateNames[c.State]), logging.Err(err))
+		s.writeError(w, statusCode(err), err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, newStatusResponse(s.alarm.Status(), s.hasToken(r)))
+}
+
+// hasToken returns true if the request has the bearer token.
+func (s *Server) hasToken(r *http.Request) bool {
+	if s.opts.Token == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.Token)) == 1
+}
+
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, alarm.ErrBadCode):
+		return http.StatusUnauthorized
+	case errors.Is(err, alarm.ErrCodeNotValid), errors.Is(err, alarm.ErrNotAllowed):
+		return http.StatusForbidden
+	case errors.Is(err, alarm.ErrLockedOut):
+		return http.StatusTooManyRequests
+	case errors.Is(err, alarm.ErrInvalidTransition):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// ErrorResponse is returned when a request fails.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func (s *Server) readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return false
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(v); err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return false
+	}
+	return true
+}
+
+func (s *Server) writeError(w http.ResponseWriter, status int, err error) {
+	s.writeJSON(w, status, ErrorResponse{Error: err.Error()})
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.opts.Logger.Warn("Failed to write response", logging.Err(err))
+	}
+}
@@ -0,0 +1,213 @@
+package web
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-h/alarm"
+	"github.com/a-h/alarm/journal"
+)
+
+func TestCommands(t *testing.T) {
+	a := alarm.New("1234")
+	defer a.Close()
+	a.LockoutAfter = 0
+	s, err := New(a, Options{Token: "secret", Code: "1234"})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		path          string
+		body          string
+		token         string
+		expected      int
+		expectedState string
+	}{
+		{name: "bad code", path: "/api/arm", body: `{"mode": "away", "code": "0000"}`, expected: http.StatusUnauthorized, expectedState: "Disarmed"},
+		{name: "no code", path: "/api/arm", body: `{"mode": "away"}`, expected: http.StatusUnauthorized, expectedState: "Disarmed"},
+		{name: "wrong token", path: "/api/arm", body: `{"mode": "away"}`, token: "guess", expected: http.StatusUnauthorized, expectedState: "Disarmed"},
+		{name: "unknown mode", path: "/api/arm", body: `{"mode": "vacation", "code": "1234"}`, expected: http.StatusBadRequest, expectedState: "Disarmed"},
+		{name: "malformed", path: "/api/arm", body: `away`, expected: http.StatusBadRequest, expectedState: "Disarmed"},
+		{name: "arm", path: "/api/arm", body: `{"mode": "night", "code": "1234"}`, expected: http.StatusOK, expectedState: "Arming"},
+		{name: "arm again", path: "/api/arm", body: `{"mode": "night", "code": "1234"}`, expected: http.StatusConflict, expectedState: "Arming"},
+		{name: "disarm with token", path: "/api/disarm", body: `{}`, token: "secret", expected: http.StatusOK, expectedState: "Disarmed"},
+		{name: "trigger", path: "/api/trigger", body: `{"code": "1234"}`, expected: http.StatusOK, expectedState: "Triggered"},
+		{name: "disarm", path: "/api/disarm", body: `{"code": "1234"}`, expected: http.StatusOK, expectedState: "Disarmed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(test.body))
+			if test.token != "" {
+				r.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			if w.Code != test.expected {
+				t.Errorf("expected status %d, got %d: %s", test.expected, w.Code, w.Body.String())
+			}
+			if state := alarm.StateNames[a.Status().State]; state != test.expectedState {
+				t.Errorf("expected state %v, got %v", test.expectedState, state)
+			}
+		})
+	}
+}
+
+func TestStatusAndKeys(t *testing.T) {
+	a := alarm.New("1234")
+	defer a.Close()
+	if err := a.AddZone(alarm.Zone{Name: "door"}); err != nil {
+		t.Fatalf("failed to add zone: %v", err)
+	}
+	a.SetZoneOpen("door", true)
+	s, err := New(a, Options{Token: "secret", Code: "1234"})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/keys", strings.NewReader(`{"keys": "A12"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var status StatusResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to read status: %v", err)
+	}
+	if status.Display != "A**" {
+		t.Errorf("expected the typed digits to be masked, got %q", status.Display)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+	status = StatusResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to read status: %v", err)
+	}
+	if status.State != "Disarmed" || status.Display != "A**" {
+		t.Errorf("expected the keys to be shown masked on the display, got %+v", status)
+	}
+	if len(status.Zones) != 1 || !status.Zones[0].Open {
+		t.Errorf("expected the door to be open, got %+v", status.Zones)
+	}
+
+	// The display is shown to callers with the token.
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	s.ServeHTTP(w, r)
+	status = StatusResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to read status: %v", err)
+	}
+	if status.Display != "A12" {
+		t.Errorf("expected the keys to be shown on the display, got %q", status.Display)
+	}
+
+	// The exit delay countdown isn't masked.
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/keys", strings.NewReader(`{"keys": "34#"}`)))
+	status = StatusResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to read status: %v", err)
+	}
+	if status.State != "Arming" || status.Display == "" || strings.Contains(status.Display, "*") {
+		t.Errorf("expected the countdown to be shown, got %+v", status)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alarm-web")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	j, err := journal.Open(filepath.Join(dir, "journal.jsonl"))
+	if err != nil {
+		t.Fatalf("failed to open journal: %v", err)
+	}
+	defer j.Close()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, typ := range []journal.Type{journal.TypePowerUp, journal.TypeArming, journal.TypeArmed, journal.TypeDisarmed} {
+		if err := j.Write(journal.Entry{Time: start.Add(time.Duration(i) * time.Hour), Type: typ}); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+	s, err := New(nil, Options{Token: "secret", Code: "1234", Journal: j})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	get := func(url, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	// The history shows when the house is empty, so the token is required.
+	for _, token := range []string{"", "wrong"} {
+		if w := get("/api/events", token); w.Code != http.StatusUnauthorized {
+			t.Errorf("expected the events to require the token, got %d", w.Code)
+		}
+	}
+
+	tests := []struct {
+		query    string
+		expected []journal.Type
+	}{
+		{query: "", expected: []journal.Type{journal.TypePowerUp, journal.TypeArming, journal.TypeArmed, journal.TypeDisarmed}},
+		{query: "?limit=1", expected: []journal.Type{journal.TypeDisarmed}},
+		{query: "?type=arming&type=disarmed", expected: []journal.Type{journal.TypeArming, journal.TypeDisarmed}},
+		{query: "?from=2020-01-01T01:00:00Z&to=2020-01-01T03:00:00Z", expected: []journal.Type{journal.TypeArming, journal.TypeArmed}},
+		{query: "?from=2021-01-01T00:00:00Z", expected: []journal.Type{}},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			w := get("/api/events"+test.query, "secret")
+			var entries []journal.Entry
+			if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+				t.Fatalf("failed to read events: %v", err)
+			}
+			actual := []journal.Type{}
+			for _, e := range entries {
+				actual = append(actual, e.Type)
+			}
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+
+	if w := get("/api/events?from=yesterday", "secret"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected a bad request for an invalid time, got %d", w.Code)
+	}
+}
+
+func TestKeypadPage(t *testing.T) {
+	s, err := New(nil, Options{})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "/api/keys") {
+		t.Errorf("expected the keypad page, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected not found, got %d", w.Code)
+	}
+}
@@ -0,0 +1,185 @@
+package alarm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/a-h/alarm/logging"
+)
+
+// ZoneType determines what happens when a zone is opened.
+type ZoneType int
+
+const (
+	// EntryExit zones start the entry delay when opened while the alarm is armed.
+	EntryExit ZoneType = iota
+	// Instant zones trigger the alarm immediately when opened while the alarm is armed.
+	Instant
+	// TwentyFourHour zones trigger the alarm when opened, even if the alarm is disarmed.
+	TwentyFourHour
+	// Motion zones trigger the alarm immediately when opened while the alarm is armed,
+	// unless the entry delay has already started.
+	Motion
+)
+
+// ZoneTypeNames contains the names of the various zone types.
+var ZoneTypeNames = map[ZoneType]string{
+	EntryExit:      "Entry/Exit",
+	Instant:        "Instant",
+	TwentyFourHour: "24 Hour",
+	Motion:         "Motion",
+}
+
+// DoorZone is the name of the zone used by SetDoorIsOpen.
+const DoorZone = "door"
+
+// ErrUnknownZone is returned when a zone has not been added to the alarm.
+var ErrUnknownZone = errors.New("alarm: unknown zone")
+
+// Zone is a sensor, such as a reed switch on a door, or a PIR.
+type Zone struct {
+	Name string
+	Type ZoneType
+	// EntryDelay overrides the alarm's EntryDelay for an entry/exit zone.
+	EntryDelay time.Duration
+	Open       bool
+	// Bypassed zones never trigger the alarm.
+	Bypassed bool
+	// rearmBypass is set if the zone was bypassed when the alarm re-armed.
+	rearmBypass bool
+}
+
+// ZoneChanged is sent when a zone is opened or closed.
+type ZoneChanged struct {
+	Zone Zone
+	Time time.Time
+}
+
+func (ZoneChanged) event() {}
+
+// AddZone adds a zone to the alarm. The zone starts closed and not bypassed.
+func (a *Alarm) AddZone(z Zone) (err error) {
+	a.do(func() {
+		err = a.addZone(z)
+	})
+	return
+}
+
+func (a *Alarm) addZone(z Zone) error {
+	if _, ok := a.zone(z.Name); ok {
+		return fmt.Errorf("alarm: zone %q already exists", z.Name)
+	}
+	z.Open = false
+	z.Bypassed = false
+	a.zones = append(a.zones, z)
+	return nil
+}
+
+func (a *Alarm) zone(name string) (z *Zone, ok bool) {
+	for i := range a.zones {
+		if a.zones[i].Name == name {
+			return &a.zones[i], true
+		}
+	}
+	return nil, false
+}
+
+// SetZoneOpen is used to set whether a zone is open or not.
+func (a *Alarm) SetZoneOpen(name string, open bool) (err error) {
+	a.do(func() {
+		err = a.setZoneOpen(name, open)
+	})
+	return
+}
+
+func (a *Alarm) setZoneOpen(name string, open bool) error {
+	z, ok := a.zone(name)
+	if !ok {
+		return ErrUnknownZone
+	}
+	if z.Open == open {
+		// No change.
+		return nil
+	}
+	z.Open = open
+	a.emit(ZoneChanged{Zone: *z, Time: time.Now()})
+	if !open || z.Bypassed {
+		return nil
+	}
+	zoneType, monitored := a.mode.zoneType(z.Type)
+	if a.state != Armed && a.state != Triggering && zoneType != TwentyFourHour {
+		return nil
+	}
+	switch {
+	case !monitored:
+		a.Logger.Debug("Zone is not monitored", logging.F(logging.KeyZone, z.Name), logging.F(logging.KeyMode, ModeNames[a.mode]))
+	case zoneType == TwentyFourHour && a.state != Triggered:
+		a.Logger.Warn("Triggering alarm due to 24 hour zone opening", logging.F(logging.KeyZone, z.Name))
+		a.alarmZone(z.Name)
+		a.trigger(CauseZone)
+	case zoneType == EntryExit && a.state == Armed:
+		a.Logger.Warn("Triggering alarm due to zone opening", logging.F(logging.KeyZone, z.Name))
+		entryDelay := a.EntryDelay
+		if z.EntryDelay > 0 {
+			entryDelay = z.EntryDelay
+		}
+		a.alarmZone(z.Name)
+		a.triggering(entryDelay, CauseZone)
+	case zoneType == Instant:
+		a.Logger.Warn("Triggering alarm due to instant zone opening", logging.F(logging.KeyZone, z.Name))
+		a.alarmZone(z.Name)
+		a.trigger(CauseZone)
+	case zoneType == Motion && a.state == Armed:
+		a.Logger.Warn("Triggering alarm due to motion", logging.F(logging.KeyZone, z.Name))
+		a.alarmZone(z.Name)
+		a.trigger(CauseZone)
+	}
+	return nil
+}
+
+// zoneType returns how a zone of type t behaves in the mode, and whether it is
+// monitored at all.
+func (m Mode) zoneType(t ZoneType) (zoneType ZoneType, monitored bool) {
+	if t == TwentyFourHour || m == Away {
+		return t, true
+	}
+	if t == Motion {
+		return t, false
+	}
+	if m == Night && t == EntryExit {
+		return Instant, true
+	}
+	return t, true
+}
+
+// BypassZone sets whether a zone is bypassed. Bypassed zones never trigger
+// the alarm, e.g. a window that's been left open.
+func (a *Alarm) BypassZone(name string, bypassed bool) (err error) {
+	a.do(func() {
+		z, ok := a.zone(name)
+		if !ok {
+			err = ErrUnknownZone
+			return
+		}
+		if z.Bypassed == bypassed {
+			return
+		}
+		z.Bypassed = bypassed
+		a.Logger.Info("Zone bypass changed", logging.F(logging.KeyZone, z.Name), logging.F("bypassed", bypassed))
+		a.emit(ZoneChanged{Zone: *z, Time: time.Now()})
+		a.save()
+	})
+	return
+}
+
+// SetDoorIsOpen is used to set whether the door is open or not. The door zone
+// is added the first time it's used.
+func (a *Alarm) SetDoorIsOpen(open bool) {
+	a.do(func() {
+		if _, ok := a.zone(DoorZone); !ok {
+			a.addZone(Zone{Name: DoorZone, Type: EntryExit})
+		}
+		a.setZoneOpen(DoorZone, open)
+	})
+}
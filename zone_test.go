@@ -0,0 +1,165 @@
+package alarm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestZones(t *testing.T) {
+	tests := []struct {
+		name                string
+		start               State
+		mode                Mode
+		zoneType            ZoneType
+		bypassed            bool
+		expectedState       State
+		expectedAlarmStarts int
+	}{
+		{
+			name:          "opening an entry/exit zone while armed starts the entry delay",
+			start:         Armed,
+			zoneType:      EntryExit,
+			expectedState: Triggering,
+		},
+		{
+			name:          "opening an entry/exit zone while disarmed does nothing",
+			start:         Disarmed,
+			zoneType:      EntryExit,
+			expectedState: Disarmed,
+		},
+		{
+			name:                "opening an instant zone while armed triggers the alarm",
+			start:               Armed,
+			zoneType:            Instant,
+			expectedState:       Triggered,
+			expectedAlarmStarts: 1,
+		},
+		{
+			name:                "opening an instant zone during the entry delay triggers the alarm",
+			start:               Triggering,
+			zoneType:            Instant,
+			expectedState:       Triggered,
+			expectedAlarmStarts: 1,
+		},
+		{
+			name:                "opening a 24 hour zone while disarmed triggers the alarm",
+			start:               Disarmed,
+			zoneType:            TwentyFourHour,
+			expectedState:       Triggered,
+			expectedAlarmStarts: 1,
+		},
+		{
+			name:                "motion while armed triggers the alarm",
+			start:               Armed,
+			zoneType:            Motion,
+			expectedState:       Triggered,
+			expectedAlarmStarts: 1,
+		},
+		{
+			name:          "motion during the entry delay is ignored",
+			start:         Triggering,
+			zoneType:      Motion,
+			expectedState: Triggering,
+		},
+		{
+			name:          "motion during the exit delay is ignored",
+			start:         Arming,
+			zoneType:      Motion,
+			expectedState: Arming,
+		},
+		{
+			name:          "motion is ignored when armed in home mode",
+			start:         Armed,
+			mode:          Home,
+			zoneType:      Motion,
+			expectedState: Armed,
+		},
+		{
+			name:          "opening an entry/exit zone when armed in home mode starts the entry delay",
+			start:         Armed,
+			mode:          Home,
+			zoneType:      EntryExit,
+			expectedState: Triggering,
+		},
+		{
+			name:          "motion is ignored when armed in night mode",
+			start:         Armed,
+			mode:          Night,
+			zoneType:      Motion,
+			expectedState: Armed,
+		},
+		{
+			name:                "opening an entry/exit zone when armed in night mode triggers the alarm",
+			start:               Armed,
+			mode:                Night,
+			zoneType:            EntryExit,
+			expectedState:       Triggered,
+			expectedAlarmStarts: 1,
+		},
+		{
+			name:          "opening a bypassed zone does nothing",
+			start:         Armed,
+			zoneType:      Instant,
+			bypassed:      true,
+			expectedState: Armed,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var actualAlarmStarts int
+			alarm := New("1234")
+			alarm.state = test.start
+			alarm.mode = test.mode
+			alarm.StartAlarm = func() {
+				actualAlarmStarts++
+			}
+			if err := alarm.AddZone(Zone{Name: "zone", Type: test.zoneType}); err != nil {
+				t.Fatalf("failed to add zone: %v", err)
+			}
+			if err := alarm.BypassZone("zone", test.bypassed); err != nil {
+				t.Fatalf("failed to bypass zone: %v", err)
+			}
+			if err := alarm.SetZoneOpen("zone", true); err != nil {
+				t.Fatalf("failed to open zone: %v", err)
+			}
+			status := alarm.Status()
+			alarm.Close()
+			if status.State != test.expectedState {
+				t.Errorf("expected state: %v, got %v", test.expectedState, status.State)
+			}
+			if actualAlarmStarts != test.expectedAlarmStarts {
+				t.Errorf("expected alarm starts: %v, got %v", test.expectedAlarmStarts, actualAlarmStarts)
+			}
+			if len(status.Zones) != 1 || !status.Zones[0].Open {
+				t.Errorf("expected the zone to be open, got %+v", status.Zones)
+			}
+		})
+	}
+}
+
+func TestZoneErrors(t *testing.T) {
+	alarm := New("1234")
+	defer alarm.Close()
+	if err := alarm.SetZoneOpen("garage", true); err != ErrUnknownZone {
+		t.Errorf("expected unknown zone error, got %v", err)
+	}
+	if err := alarm.AddZone(Zone{Name: "garage", Type: Instant}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := alarm.AddZone(Zone{Name: "garage", Type: Instant}); err == nil {
+		t.Errorf("expected an error when adding the same zone twice")
+	}
+}
+
+func TestZoneEntryDelay(t *testing.T) {
+	alarm := New("1234")
+	defer alarm.Close()
+	alarm.state = Armed
+	alarm.EntryDelay = time.Hour
+	alarm.AddZone(Zone{Name: "back door", Type: EntryExit, EntryDelay: time.Millisecond * 5})
+	alarm.SetZoneOpen("back door", true)
+	time.Sleep(time.Millisecond * 100)
+	if state := alarm.Status().State; state != Triggered {
+		t.Errorf("expected the zone's entry delay to be used, got state %v", state)
+	}
+}